@@ -34,8 +34,9 @@ if [ "$SHOULD_UNINSTALL" = true ]; then
 fi
 
 # setup golang workspace
+rm -rf $WORKSPACE
 mkdir -p $WORKSPACE
-rsync -rum --delete --exclude ".*" src/ $WORKSPACE
+cp -R src/. $WORKSPACE
 if [ "$SHOULD_INSTALL" = false ]; then
   if [ -e .go/bin/bottle ]; then
     rm -f .go/bin/bottle
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"bottle/debug"
+	"bottle/filemutex"
+	"bottle/filesync"
+	"bottle/shutil"
+	"bottle/toml"
+)
+
+// The cache is shared by every project, to avoid fetching the same repository
+// for each workspace.  It contains:
+//
+//   git/<key>.git                       bare mirrors of git repositories
+//   snapshots/<key>/<revision>/         copies of other checkouts at a revision
+//   snapshots/<key>/<revision>.toml     describes the snapshot (a snapshotInfo)
+//   go-get/<key>.toml                   the go-import meta of a go-get import path
+//
+// Workspaces clone git dependencies from the mirrors, so the objects are
+// hardlinked instead of being copied.  Snapshots are copied, because their
+// VCS metadata is modified when the workspace is updated.
+//
+// The modification time of a mirror or snapshot description is updated each
+// time it is used, so that unused entries can be pruned.
+//
+// Each mirror and snapshot has a lock file next to it ("<key>.git.lock" or
+// "<revision>.lock"), which is held while the entry is created, updated, or
+// copied into a workspace, and while it is pruned, so that bottle processes
+// don't use an entry which another process is changing or removing.
+
+// cacheDir returns the location of the cache, which is "$BOTTLE_CACHE" if it
+// is set, the "cache" in the user's config, or else "bottle" in the user's
+// XDG cache directory.
+func cacheDir() string {
+	env := shutil.Env()
+	switch {
+	case len(env["BOTTLE_CACHE"]) > 0:
+		return shutil.Abspath(env["BOTTLE_CACHE"])
+	case len(userConfig.Cache) > 0:
+		return shutil.Abspath(userConfig.Cache)
+	case len(env["XDG_CACHE_HOME"]) > 0:
+		return shutil.Path(env["XDG_CACHE_HOME"], "bottle")
+	case len(env["HOME"]) > 0:
+		return shutil.Path(env["HOME"], ".cache", "bottle")
+	}
+	return shutil.Path(os.TempDir(), "bottle", ".cache")
+}
+
+func cacheKey(parts ...string) string {
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(hash[:])[:24]
+}
+
+func touch(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// tryLockCacheEntry takes the lock of a mirror or snapshot, and returns a
+// function to release it, or nil if the lock is held by another process.
+func tryLockCacheEntry(path string) (func(), error) {
+	filename := path + ".lock"
+	for {
+		shutil.MkdirParents(shutil.Dirname(filename), 0755)
+		mutex, err := filemutex.New(filename)
+		if err != nil {
+			return nil, err
+		}
+		if !mutex.TryLock() {
+			mutex.Close()
+			return nil, nil
+		}
+
+		// NOTE: The lock file is removed when the entry is pruned, so a
+		//       process which opened it before then must lock the new file
+		if mutex.SameFile(filename) {
+			return func() {
+				mutex.Unlock()
+				mutex.Close()
+			}, nil
+		}
+		mutex.Unlock()
+		mutex.Close()
+	}
+}
+
+// lockCacheEntry is like tryLockCacheEntry, but waits until the lock is
+// released by other processes (or the context is cancelled).
+func lockCacheEntry(ctx context.Context, path string) (func(), error) {
+	for {
+		unlock, err := tryLockCacheEntry(path)
+		if err != nil || unlock != nil {
+			return unlock, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// gitMirror returns the path of the cache's mirror of a git repository, and a
+// function to release the mirror's lock, which the caller holds until it has
+// finished cloning or fetching from the mirror.  The mirror is created if it
+// doesn't exist, and is updated unless it already contains the requested tag
+// or commit.  A mirror which fails to be created (or is cancelled) is removed.
+func gitMirror(ctx context.Context, src string, rev Revision) (mirror string, unlock func(), err error) {
+	defer debug.TimedFunction(time.Now(), "gitMirror("+src+")")
+
+	mirror = gitMirrorPath(src)
+	release, err := lockCacheEntry(ctx, mirror)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+
+	if !shutil.Exists(mirror) {
+		if offline {
+			return "", nil, &OfflineError{Package: src, Reason: "the repository is not in the cache"}
+		}
+		output, err := shutil.CmdContext(ctx, `git`, `clone`, `--mirror`, `--quiet`, src, mirror).Try()
+		if err != nil {
+			os.RemoveAll(mirror)
+			tabbedOutput := strings.Join(strings.Split(output, "\n"), "\n\t")
+			return "", nil, fmt.Errorf("resolver: error cloning repository with git:\n\n\t%s\n", tabbedOutput)
+		}
+	} else if offline {
+		// NOTE: Branches are resolved to wherever they were last fetched
+		if !gitMirrorContains(ctx, mirror, rev) {
+			return "", nil, &OfflineError{Package: src, Reason: describeRevision(rev) + " is not in the cache"}
+		}
+	} else if gitMirrorNeedsUpdate(ctx, mirror, rev) {
+		if _, err := git(ctx, mirror, `remote`, `update`, `--prune`); err != nil {
+			return "", nil, err
+		}
+	}
+
+	touch(mirror)
+	return mirror, release, nil
+}
+
+func gitMirrorPath(src string) string {
+	return shutil.Path(cacheDir(), "git", cacheKey(src)+".git")
+}
+
+func gitMirrorNeedsUpdate(ctx context.Context, mirror string, rev Revision) bool {
+	if rev.Refresh || (len(rev.Rev) == 0 && len(rev.Tag) == 0) {
+		return true // branches may have moved
+	}
+	return !gitMirrorContains(ctx, mirror, rev)
+}
+
+func gitMirrorContains(ctx context.Context, mirror string, rev Revision) bool {
+	// NOTE: Branches are stored in a mirror as local branches
+	commitish := gitCommitish(rev)
+	switch {
+	case len(rev.Branch) > 0:
+		commitish = "refs/heads/" + rev.Branch
+	case commitish == gitDefaultBranch:
+		commitish = "HEAD"
+	}
+	_, err := git(ctx, mirror, `rev-parse`, `--verify`, `--quiet`, commitish+"^{commit}")
+	return err == nil
+}
+
+// goGetIndexEntry records the go-import meta which was fetched for an import
+// path, so that a go-get dependency can be resolved from the cache's mirror of
+// its repository while offline.
+type goGetIndexEntry struct {
+	Prefix     string
+	VCS        string
+	Repository string
+}
+
+func goGetIndexPath(importPath string) string {
+	return shutil.Path(cacheDir(), "go-get", cacheKey(importPath)+".toml")
+}
+
+// saveGoGetMeta records the go-import meta of an import path.  Failing to
+// record it isn't an error, because the meta has already been fetched.
+func saveGoGetMeta(importPath string, meta *goImportMeta) {
+	data, err := toml.Marshal(goGetIndexEntry{Prefix: meta.prefix, VCS: meta.vcs, Repository: meta.repo})
+	if err != nil {
+		return
+	}
+	filename := goGetIndexPath(importPath)
+	if shutil.IsRegularFile(filename) && bytes.Equal(data, shutil.Binread(filename)) {
+		return
+	}
+
+	// NOTE: The file is renamed into place, so another process never reads
+	//       a partially written entry
+	shutil.MkdirParents(shutil.Dirname(filename), 0755)
+	tmp := fmt.Sprintf("%s.%d.tmp", filename, os.Getpid())
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+	}
+}
+
+// cachedGoGetMeta returns the go-import meta which was recorded for an import
+// path, or nil if it hasn't been fetched.
+func cachedGoGetMeta(importPath string) *goImportMeta {
+	filename := goGetIndexPath(importPath)
+	if !shutil.IsRegularFile(filename) {
+		return nil
+	}
+	var entry goGetIndexEntry
+	if err := toml.Unmarshal(shutil.Binread(filename), &entry); err != nil || len(entry.Repository) == 0 {
+		return nil
+	}
+	return &goImportMeta{prefix: entry.Prefix, vcs: entry.VCS, repo: entry.Repository}
+}
+
+type snapshotInfo struct {
+	Protocol   string
+	Repository string
+	Revision   string
+	Hash       string // the content hash of the snapshot, to verify it
+}
+
+func snapshotPath(protocol, repository, revision string) string {
+	name := strings.NewReplacer("/", "_", ":", "_").Replace(revision)
+	return shutil.Path(cacheDir(), "snapshots", cacheKey(protocol, repository), name)
+}
+
+// withSnapshots wraps a resolver so that a pinned revision is copied from the
+// cache when the package isn't in the workspace, and each revision which is
+// resolved is saved in the cache.
+func withSnapshots(protocol string, fn ResolverFunc) ResolverFunc {
+	return func(ctx context.Context, src string, rev Revision, pkg string, workspace string) (string, error) {
+		dest := shutil.Path(workspace, "src", pkg)
+		if len(rev.Rev) > 0 && !rev.Refresh && !shutil.Exists(dest) {
+			if copySnapshot(ctx, snapshotPath(protocol, src, rev.Rev), dest) {
+				return rev.Rev, nil
+			}
+		}
+
+		revision, err := fn(ctx, src, rev, pkg, workspace)
+		if err != nil && err != AlreadyResolved {
+			return revision, err
+		}
+
+		// Save a snapshot of the new revision
+		snapshot := snapshotPath(protocol, src, revision)
+		if !shutil.Exists(snapshot) {
+			saveSnapshot(ctx, snapshotInfo{Protocol: protocol, Repository: src, Revision: revision}, dest, snapshot)
+		}
+		return revision, err
+	}
+}
+
+// copySnapshot copies a snapshot into the workspace, or returns false if the
+// package must be resolved instead.
+func copySnapshot(ctx context.Context, snapshot, dest string) bool {
+	unlock, err := lockCacheEntry(ctx, snapshot)
+	if err != nil {
+		return false
+	}
+	defer unlock()
+	if !shutil.IsDirectory(snapshot) {
+		return false
+	}
+
+	if _, err := filesync.Sync(snapshot, dest, filesync.Options{EmptyDirs: true}); err != nil {
+		shutil.RmRecursive(dest)
+		return false
+	}
+	touch(snapshot + ".toml")
+	return true
+}
+
+// saveSnapshot copies a package into the cache.  Failing to save a snapshot
+// isn't an error, because the package has already been resolved.
+func saveSnapshot(ctx context.Context, info snapshotInfo, dest, snapshot string) {
+	unlock, err := lockCacheEntry(ctx, snapshot)
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if shutil.Exists(snapshot) {
+		return // NOTE: saved by another process
+	}
+
+	hash, err := hashTree(dest)
+	if err != nil {
+		return
+	}
+	info.Hash = hash
+
+	data, err := toml.Marshal(info)
+	if err != nil {
+		return
+	}
+
+	tmp := snapshot + ".tmp"
+	shutil.RmRecursive(tmp)
+	if _, err := filesync.Sync(dest, tmp, filesync.Options{EmptyDirs: true}); err != nil {
+		shutil.RmRecursive(tmp)
+		return
+	}
+	if err := os.Rename(tmp, snapshot); err != nil {
+		shutil.RmRecursive(tmp)
+		return
+	}
+	ioutil.WriteFile(snapshot+".toml", data, 0644)
+}
+
+type cacheEntry struct {
+	kind     string // "git" or "snapshot"
+	path     string
+	info     snapshotInfo
+	lastUsed time.Time
+}
+
+// listCache returns every mirror and snapshot in the cache, sorted by the
+// repository and revision.
+func listCache() []cacheEntry {
+	var entries []cacheEntry
+
+	gitDir := shutil.Path(cacheDir(), "git")
+	if shutil.IsDirectory(gitDir) {
+		for _, file := range shutil.LsLong(gitDir) {
+			mirror := shutil.Path(gitDir, file.Name())
+			if !file.IsDir() || !strings.HasSuffix(file.Name(), ".git") {
+				continue
+			}
+			url, _ := git(context.Background(), mirror, `config`, `--get`, `remote.origin.url`)
+			entries = append(entries, cacheEntry{
+				kind:     "git",
+				path:     mirror,
+				info:     snapshotInfo{Protocol: "git", Repository: url},
+				lastUsed: file.ModTime(),
+			})
+		}
+	}
+
+	snapshotsDir := shutil.Path(cacheDir(), "snapshots")
+	if shutil.IsDirectory(snapshotsDir) {
+		descriptions, _ := filepath.Glob(shutil.Path(snapshotsDir, "*", "*.toml"))
+		for _, description := range descriptions {
+			stat, err := os.Stat(description)
+			if err != nil {
+				continue
+			}
+			entry := cacheEntry{kind: "snapshot", path: strings.TrimSuffix(description, ".toml"), lastUsed: stat.ModTime()}
+			toml.Unmarshal(shutil.Binread(description), &entry.info)
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].info.Repository != entries[j].info.Repository {
+			return entries[i].info.Repository < entries[j].info.Repository
+		}
+		return entries[i].info.Revision < entries[j].info.Revision
+	})
+	return entries
+}
+
+// verifyCacheEntry checks the integrity of a mirror, or that a snapshot
+// hasn't been modified since it was saved.
+func verifyCacheEntry(entry cacheEntry) error {
+	switch entry.kind {
+	case "git":
+		_, err := git(context.Background(), entry.path, `fsck`, `--no-progress`, `--no-dangling`)
+		return err
+	default:
+		if !shutil.IsDirectory(entry.path) {
+			return fmt.Errorf("snapshot is missing")
+		}
+		hash, err := hashTree(entry.path)
+		if err != nil {
+			return err
+		}
+		if hash != entry.info.Hash {
+			return fmt.Errorf("snapshot has been modified (expected %s, found %s)", entry.info.Hash, hash)
+		}
+	}
+	return nil
+}
+
+// removeCacheEntry removes a mirror or snapshot, whose lock must be held by
+// this process.  The lock file is removed too (see tryLockCacheEntry).
+func removeCacheEntry(entry cacheEntry) {
+	shutil.RmRecursive(entry.path)
+	if entry.kind == "snapshot" {
+		shutil.RmRecursive(entry.path + ".toml")
+	}
+	os.Remove(entry.path + ".lock")
+}
+
+// diskUsage returns the total size of the files in a directory.
+func diskUsage(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"bottle/shutil"
+)
+
+// A workspace is only removed while this process holds its exclusive lock,
+// so that it isn't removed while another bottle process syncs or uses it.
+// The workspace is renamed before it is removed, so that a process which is
+// waiting for the lock can create the workspace again straight away (see
+// tryLockWorkspace).
+
+// checkWorkspace returns an error if a project's workspace shouldn't be
+// removed: a workspace which was chosen by the user must contain the lock
+// file of a bottle workspace, and can't contain the project.
+func checkWorkspace(cfg *Config) error {
+	if shutil.IsSubdir(cfg.Project, cfg.Workspace) {
+		return fmt.Errorf(`the workspace "%s" contains the project, so it can't be removed`, cfg.Workspace)
+	}
+	if cfg.Workspace != defaultWorkspaceDir(cfg) && !shutil.IsRegularFile(shutil.Path(cfg.Workspace, ".bottle-lock")) {
+		return fmt.Errorf(`"%s" doesn't look like a bottle workspace (it doesn't have a ".bottle-lock"), so it can't be removed`, cfg.Workspace)
+	}
+	return nil
+}
+
+// dependencyPaths returns the paths in a workspace which contain the fetched
+// dependencies, and the packages and executables built from them, but not
+// the project's root package.
+func dependencyPaths(cfg *Config) []string {
+	var paths []string
+	for _, dir := range []string{"bin", "pkg"} {
+		if shutil.Exists(shutil.Path(cfg.Workspace, dir)) {
+			paths = append(paths, shutil.Path(cfg.Workspace, dir))
+		}
+	}
+
+	// NOTE: The root package can be nested (eg. "github.com/a/b"), so each of
+	//       its parent directories is kept, but not the other packages in it
+	dir := shutil.Path(cfg.Workspace, "src")
+	parts := strings.Split(cfg.Package.Name, "/")
+	for i, part := range parts {
+		entries, _ := ioutil.ReadDir(dir)
+		for _, info := range entries {
+			if info.Name() != part {
+				paths = append(paths, shutil.Path(dir, info.Name()))
+			}
+		}
+		if i < len(parts)-1 {
+			dir = shutil.Path(dir, part)
+		}
+	}
+	return paths
+}
+
+var workspaceNamePattern = regexp.MustCompile(`-[0-9a-f]{12}$`)
+
+// listWorkspaces returns each workspace in the temporary directory of
+// workspaces.  A workspace's directory is named by its package, which may
+// contain slashes, and a hash.
+func listWorkspaces() []string {
+	var workspaces []string
+	root := workspacesDir()
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() || path == root {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir // NOTE: eg. ".cache" and ".publish"
+		}
+		if workspaceNamePattern.MatchString(info.Name()) && (shutil.Exists(shutil.Path(path, ".bottle-lock")) || shutil.IsDirectory(shutil.Path(path, "src"))) {
+			workspaces = append(workspaces, path)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return workspaces
+}
+
+// removeWorkspace removes a workspace, which must be locked by this process.
+func removeWorkspace(workspace string) error {
+	removing := shutil.Path(filepath.Dir(workspace), "."+filepath.Base(workspace)+".removing-"+strconv.Itoa(os.Getpid()))
+	if err := os.Rename(workspace, removing); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(removing); err != nil {
+		return err
+	}
+
+	// Remove the parent directories of a nested package name (eg. "github.com")
+	root := workspacesDir()
+	for dir := filepath.Dir(workspace); shutil.IsSubdir(dir, root) && dir != root; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break // NOTE: the directory isn't empty
+		}
+	}
+	return nil
+}
@@ -2,16 +2,26 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"bottle/debug"
+	"bottle/filesync"
+	"bottle/gomod"
 	sh "bottle/shutil"
+	"bottle/toml"
 )
 
 type BuildFlags struct{ ldflags, outfile, outdir string }
@@ -91,6 +101,10 @@ func buildProject(cfg *Config, pwd string, flags BuildFlags) {
 func execTool(cfg *Config, tool string, args []string) {
 	defer debug.TimedFunction(time.Now(), "execTool()")
 
+	// NOTE: The tool may run for a long time, so the workspace isn't locked
+	//       while it runs (except to copy the changes back afterwards)
+	heldLock.Unlock()
+
 	workdir := sh.Path(cfg.Workspace, "src", cfg.Package.Name)
 	cmd := sh.Cmd(tool, args...)
 	cmd.Env = append(cmd.Env, "GOPATH="+cfg.Workspace)
@@ -104,7 +118,661 @@ func execTool(cfg *Config, tool string, args []string) {
 	}
 
 	if !cfg.Missing {
-		sh.Cmd(`rsync`, `-rum`, workdir+"/", cfg.Package.Root).Run()
+		lock, err := lockWorkspace(cfg.Workspace, true)
+		if err != nil {
+			sh.Stderr(err.Error())
+			sh.Exit(1)
+		}
+		defer lock.Unlock()
+
+		if err := restoreRenames(cfg); err != nil {
+			sh.Stderr(err.Error())
+			sh.Exit(1)
+		}
+		// NOTE: The tool may rewrite files without changing them (eg. "gofmt
+		//       -w"), so their contents are compared instead of their times
+		changes, err := filesync.Sync(workdir, cfg.Package.Root, filesync.Options{Update: true, Checksum: true})
+		if err != nil {
+			sh.Stderr("error: failed to copy changes to '" + cfg.Package.Root + "': " + err.Error() + "\n")
+			sh.Exit(1)
+		}
+		for _, change := range changes {
+			sh.Stderr(change.Op.String() + " " + change.Path + "\n")
+		}
+	}
+}
+
+type RunFlags struct{ bin string }
+
+func runBin(cfg *Config, pwd string, args []string, flags RunFlags) {
+	defer debug.TimedFunction(time.Now(), "runBin()")
+
+	// Select the executable to run
+	name := flags.bin
+	if len(name) == 0 && len(cfg.Bin) == 1 {
+		name = cfg.Bin[0].Name
+	}
+	var names []string
+	binPath := ""
+	for _, bincfg := range cfg.Bin {
+		names = append(names, bincfg.Name)
+		if bincfg.Name == name {
+			binPath = bincfg.Path
+		}
+	}
+	switch {
+	case len(cfg.Bin) == 0:
+		sh.Stderr("error: the project doesn't have any [[bin]] targets\n")
+		sh.Exit(1)
+	case len(name) == 0:
+		sh.Stderr("error: the project has several [[bin]] targets, choose one with '--bin' (" + strings.Join(names, ", ") + ")\n")
+		sh.Exit(1)
+	case len(binPath) == 0:
+		sh.Stderr("error: the project doesn't have a [[bin]] target named '" + name + "' (" + strings.Join(names, ", ") + ")\n")
+		sh.Exit(1)
+	}
+
+	// Build only the selected executable
+	targetDir := sh.Path(cfg.Workspace, "src", cfg.Package.Name)
+	exePath := sh.Path(cfg.Workspace, "bin", name)
+	pkgDir := "./" + path.Dir(path.Clean(filepath.ToSlash(binPath)))
+	cmd := sh.Cmd(`go`, `build`, `-o`, exePath, pkgDir)
+	cmd.Env = append([]string{"GOPATH=" + cfg.Workspace}, cmd.Env...)
+	cmd.Dir = targetDir
+	output, err := cmd.Try()
+	output = strings.Replace(output, targetDir, ".", -1)
+	if err != nil {
+		sh.Stderr(output)
+		sh.Exit(1)
+	}
+
+	// NOTE: The program may run for a long time, so the workspace isn't
+	//       locked while it runs
+	heldLock.Unlock()
+
+	// NOTE: A terminal sends SIGINT and SIGQUIT to the program as well as to
+	//       bottle, so those are only ignored; other signals are forwarded.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append([]os.Signal{os.Interrupt, syscall.SIGQUIT}, forwardedSignals...)...)
+	defer signal.Stop(signals)
+
+	cmd = sh.Cmd(exePath, args...)
+	cmd.Dir = pwd
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		sh.Stderr("error: failed to run '" + exePath + "': " + err.Error() + "\n")
+		sh.Exit(1)
+	}
+	go func() {
+		for sig := range signals {
+			if sig != os.Interrupt && sig != syscall.SIGQUIT {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	// Exit with the program's exit code
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			sh.Exit(128 + int(status.Signal()))
+		}
+		sh.Exit(exitErr.ExitCode())
+	} else if err != nil {
+		sh.Stderr("error: failed to run '" + exePath + "': " + err.Error() + "\n")
+		sh.Exit(1)
+	}
+}
+
+type TestFlags struct {
+	run                  string
+	race, cover, verbose bool
+	deps                 bool // also test the "path" dependencies
+}
+
+func testProject(cfg *Config, deps *DependencyTracker, pwd string, args []string, flags TestFlags) {
+	defer debug.TimedFunction(time.Now(), "testProject()")
+
+	packages, err := testPackages(cfg, pwd, args)
+	if err != nil {
+		sh.Stderr("error: " + err.Error() + "\n")
+		sh.Exit(1)
+	}
+
+	// Map the directories in the workspace back to the source directories
+	targetDir := sh.Path(cfg.Workspace, "src", cfg.Package.Name)
+	sources := map[string]string{targetDir: cfg.Package.Root}
+	var pathDeps []string
+	for importPath, dir := range deps.PathDependencies() {
+		sources[sh.Path(cfg.Workspace, "src", importPath)] = dir
+		pathDeps = append(pathDeps, importPath)
+	}
+	if flags.deps {
+		sort.Strings(pathDeps)
+		for _, importPath := range pathDeps {
+			packages = append(packages, importPath+"/...")
+		}
+	}
+
+	testArgs := []string{`test`}
+	if len(flags.run) > 0 {
+		testArgs = append(testArgs, "-run", flags.run)
+	}
+	if flags.race {
+		testArgs = append(testArgs, "-race")
+	}
+	if flags.cover {
+		testArgs = append(testArgs, "-cover")
+	}
+	if flags.verbose {
+		testArgs = append(testArgs, "-v")
+	}
+	testArgs = append(testArgs, packages...)
+
+	stdout := newPathRewriter(os.Stdout, sources)
+	stderr := newPathRewriter(os.Stderr, sources)
+	cmd := sh.Cmd(`go`, testArgs...)
+	cmd.Env = append([]string{"GOPATH=" + cfg.Workspace}, cmd.Env...)
+	cmd.Dir = targetDir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	if err != nil {
+		sh.Exit(1)
+	}
+}
+
+// testPackages converts the packages given to "bottle test" into packages in
+// the workspace.  Directories (eg. "./sub/...") are relative to the working
+// directory and must be in the project's root package; anything else is an
+// import path.  With no packages, every package in the project is tested.
+func testPackages(cfg *Config, pwd string, args []string) ([]string, error) {
+	if len(args) == 0 {
+		return []string{`./...`}, nil
+	}
+
+	var packages []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, ".") && !filepath.IsAbs(arg) {
+			packages = append(packages, arg)
+			continue
+		}
+
+		dir, suffix := arg, ""
+		if strings.HasSuffix(dir, "/...") {
+			dir, suffix = strings.TrimSuffix(dir, "/..."), "/..."
+		} else if dir == "..." {
+			dir, suffix = ".", "/..."
+		}
+		if !filepath.IsAbs(dir) {
+			dir = sh.Path(pwd, dir)
+		}
+		rel, err := filepath.Rel(cfg.Package.Root, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, fmt.Errorf("the package '%s' is not in the project's root package '%s'", arg, cfg.Package.Root)
+		}
+		packages = append(packages, "./"+filepath.ToSlash(rel)+suffix)
+	}
+	return packages, nil
+}
+
+// pathRewriter replaces directories in the workspace with their source
+// directories in each line which is written to it.
+type pathRewriter struct {
+	out      io.Writer
+	replacer *strings.Replacer
+	line     []byte
+}
+
+func newPathRewriter(out io.Writer, sources map[string]string) *pathRewriter {
+	var dirs []string
+	for dir := range sources {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) }) // NOTE: nested directories first
+
+	var pairs []string
+	for _, dir := range dirs {
+		pairs = append(pairs, dir, sources[dir])
+	}
+	return &pathRewriter{out: out, replacer: strings.NewReplacer(pairs...)}
+}
+
+func (w *pathRewriter) Write(data []byte) (int, error) {
+	w.line = append(w.line, data...)
+	if i := bytes.LastIndexByte(w.line, '\n'); i >= 0 {
+		if _, err := io.WriteString(w.out, w.replacer.Replace(string(w.line[:i+1]))); err != nil {
+			return 0, err
+		}
+		w.line = w.line[i+1:]
+	}
+	return len(data), nil
+}
+
+// Flush writes the last line, if it didn't end with a newline.
+func (w *pathRewriter) Flush() {
+	if len(w.line) > 0 {
+		io.WriteString(w.out, w.replacer.Replace(string(w.line)))
+		w.line = nil
+	}
+}
+
+// checkUpdatePaths exits if an import path given to "bottle update" isn't a
+// dependency in the project's config or lockfile (or a subpackage of one).  It
+// is checked before the workspace is synced, so the lockfile isn't changed.
+func checkUpdatePaths(cfg *Config, importPaths []string) {
+	var known []string
+	for importPath, meta := range cfg.Dependencies {
+		known = append(known, importPath)
+		if len(meta.As) > 0 {
+			known = append(known, meta.As)
+		}
+	}
+	lockfile := sh.Path(cfg.Project, "Bottle.lock")
+	if !cfg.Missing && sh.IsRegularFile(lockfile) {
+		var lock Lockfile
+		if err := toml.Unmarshal(sh.Binread(lockfile), &lock); err == nil {
+			for _, locked := range lock.Dependency {
+				known = append(known, locked.ImportPath)
+			}
+		}
+	}
+
+	for _, importPath := range importPaths {
+		matched := false
+		for _, dependency := range known {
+			if importPath == dependency || strings.HasPrefix(importPath, dependency+"/") {
+				matched = true
+			}
+		}
+		if !matched {
+			sh.Stderr("error: '" + importPath + "' is not a dependency of this project\n")
+			sh.Exit(1)
+		}
+	}
+}
+
+func updateProject(deps *DependencyTracker, importPaths []string) {
+	defer debug.TimedFunction(time.Now(), "updateProject()")
+
+	changes := deps.Changes()
+	for _, change := range changes {
+		if len(change.Old.Revision) == 0 {
+			sh.Echo("Added", change.New.ImportPath, formatRevision(change.New))
+		} else {
+			sh.Echo("Updated", change.New.ImportPath, formatRevision(change.Old), "->", formatRevision(change.New))
+		}
+	}
+	if len(changes) == 0 {
+		sh.Echo("All dependencies are up to date")
+	}
+}
+
+// formatRevision returns a short description of a locked revision, such as
+// "v1.2.0 (0123456789ab)".
+func formatRevision(locked LockedDependency) string {
+	revision := locked.Revision
+	prefix := ""
+	if strings.HasPrefix(revision, "sha256:") {
+		prefix, revision = "sha256:", revision[len("sha256:"):]
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if len(locked.Version) > 0 {
+		return locked.Version + " (" + prefix + revision + ")"
+	}
+	return prefix + revision
+}
+
+func initProject(pwd string) {
+	defer debug.TimedFunction(time.Now(), "initProject()")
+
+	filename := sh.Path(pwd, "Bottle.toml")
+	if sh.Exists(filename) {
+		sh.Stderr("error: '" + filename + "' already exists\n")
+		sh.Exit(1)
+	}
+
+	data, err := InitConfig(pwd)
+	if err != nil {
+		sh.Stderr(err.Error())
+		sh.Exit(1)
+	}
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		sh.Stderr("error: failed to write '" + filename + "': " + err.Error() + "\n")
+		sh.Exit(1)
+	}
+	sh.Echo("Wrote", filename)
+}
+
+// addToProject adds a dependency to the project's config (or sets the keys of
+// its entry), and then syncs the workspace.  The config is restored if the
+// dependency can't be resolved.
+func addToProject(cfg *Config, importPath string, meta configDependency) {
+	defer debug.TimedFunction(time.Now(), "addToProject("+importPath+")")
+
+	if err := validateDependency("dependency", importPath, meta); err != nil {
+		sh.Stderr("error: " + err.Error() + "\n")
+		sh.Exit(1)
+	}
+	filename := sh.Path(cfg.Project, "Bottle.toml")
+	original := sh.Binread(filename)
+	editProject(cfg, original, setDependency(original, importPath, dependencyFields(meta)), []string{importPath})
+	sh.Echo("Added", importPath, "to", filename)
+}
+
+// removeFromProject removes a dependency from the project's config, and then
+// syncs the workspace.
+func removeFromProject(cfg *Config, importPath string) {
+	defer debug.TimedFunction(time.Now(), "removeFromProject("+importPath+")")
+
+	filename := sh.Path(cfg.Project, "Bottle.toml")
+	original := sh.Binread(filename)
+	edited, ok := removeDependency(original, importPath)
+	if !ok {
+		sh.Stderr("error: '" + importPath + "' is not a dependency in '" + filename + "'\n")
+		sh.Exit(1)
+	}
+	editProject(cfg, original, edited, nil)
+	sh.Echo("Removed", importPath, "from", filename)
+}
+
+// editProject writes the edited config and syncs the workspace, or restores
+// the original config (and lockfile) if that fails.
+func editProject(cfg *Config, original, edited []byte, update []string) {
+	// NOTE: The lockfile may be written before the sync fails
+	lockfile := sh.Path(cfg.Project, "Bottle.lock")
+	locked, err := ioutil.ReadFile(lockfile)
+	if err != nil && !os.IsNotExist(err) {
+		sh.Stderr("error: failed to read '" + lockfile + "': " + err.Error() + "\n")
+		sh.Exit(1)
+	}
+	hadLockfile := err == nil
+
+	filename := sh.Path(cfg.Project, "Bottle.toml")
+	if err := ioutil.WriteFile(filename, edited, 0644); err != nil {
+		sh.Stderr("error: failed to write '" + filename + "': " + err.Error() + "\n")
+		sh.Exit(1)
+	}
+	restore := func(err error) {
+		ioutil.WriteFile(filename, original, 0644)
+		if hadLockfile {
+			ioutil.WriteFile(lockfile, locked, 0644)
+		} else {
+			os.Remove(lockfile)
+		}
+		sh.Stderr(err.Error())
+		sh.Stderr("error: the changes to '" + filename + "' were reverted\n")
+		sh.Exit(1)
+	}
+
+	edit, err := discoverPackage(cfg.Project, filename, false)
+	if err == nil {
+		err = checkWorkspaceDir(edit)
+	}
+	if err != nil {
+		restore(fmt.Errorf("%s\n", err))
+	}
+	deps, err := NewDependencyTracker(edit)
+	if err != nil {
+		restore(err)
+	}
+	if len(update) > 0 {
+		deps.Update(update)
+	}
+	if err := trySyncWorkspace(edit, deps); err != nil {
+		restore(err)
+	}
+}
+
+func vendorProject(cfg *Config, deps *DependencyTracker) {
+	defer debug.TimedFunction(time.Now(), "vendorProject()")
+
+	manifest, err := deps.Vendor()
+	if err != nil {
+		sh.Stderr(err.Error())
+		sh.Exit(1)
+	}
+
+	count := 0
+	for _, vendored := range manifest.Dependency {
+		count += len(vendored.Packages)
+	}
+	sh.Echo(fmt.Sprintf("Vendored %d packages from %d dependencies into %s",
+		count, len(manifest.Dependency), sh.Path(cfg.Package.Root, "vendor")))
+}
+
+type ModFlags struct{ force bool }
+
+// checkGoModExport exits if the project already has a go.mod, unless it should
+// be overwritten.  It is checked before the workspace is synced.
+func checkGoModExport(cfg *Config, flags ModFlags) {
+	filename := sh.Path(cfg.Package.Root, "go.mod")
+	if sh.Exists(filename) && !flags.force {
+		sh.Stderr("error: '" + filename + "' already exists (use '--force' to overwrite it)\n")
+		sh.Exit(1)
+	}
+}
+
+func exportGoMod(cfg *Config, deps *DependencyTracker) {
+	defer debug.TimedFunction(time.Now(), "exportGoMod()")
+
+	mod, warnings := deps.GoMod()
+	for _, warning := range warnings {
+		sh.Stderr("warning: " + warning + "\n")
+	}
+
+	filename := sh.Path(cfg.Package.Root, "go.mod")
+	err := ioutil.WriteFile(filename, gomod.Format(mod), 0644)
+	if err != nil {
+		sh.Stderr("error: failed to write '" + filename + "': " + err.Error() + "\n")
+		sh.Exit(1)
+	}
+	sh.Echo("Wrote", filename)
+}
+
+type CleanFlags struct{ deps, all, dryRun bool }
+
+func cleanProject(cfg *Config, flags CleanFlags) {
+	defer debug.TimedFunction(time.Now(), "cleanProject()")
+
+	if !sh.Exists(cfg.Workspace) {
+		sh.Echo("Nothing to clean in", cfg.Workspace)
+		return
+	}
+	if err := checkWorkspace(cfg); err != nil {
+		sh.Stderr("error: " + err.Error() + "\n")
+		sh.Exit(1)
+	}
+	if !flags.dryRun {
+		lock, err := lockWorkspace(cfg.Workspace, true)
+		if err != nil {
+			sh.Stderr(err.Error())
+			sh.Exit(1)
+		}
+		defer lock.Unlock()
+	}
+
+	paths := []string{cfg.Workspace}
+	if flags.deps {
+		paths = dependencyPaths(cfg)
+	}
+	var freed int64
+	for _, dir := range paths {
+		size := diskUsage(dir)
+		freed += size
+		if flags.dryRun {
+			sh.Echo(fmt.Sprintf("Would remove %s (%s)", dir, formatSize(size)))
+			continue
+		}
+
+		var err error
+		if flags.deps {
+			err = os.RemoveAll(dir)
+		} else {
+			err = removeWorkspace(dir)
+		}
+		if err != nil {
+			sh.Stderr("error: failed to remove '" + dir + "': " + err.Error() + "\n")
+			sh.Exit(1)
+		}
+		sh.Echo(fmt.Sprintf("Removed %s (%s)", dir, formatSize(size)))
+	}
+	printCleaned(len(paths), "path", freed, flags.dryRun)
+}
+
+func cleanAllWorkspaces(flags CleanFlags) {
+	defer debug.TimedFunction(time.Now(), "cleanAllWorkspaces()")
+
+	var freed int64
+	removed := 0
+	for _, workspace := range listWorkspaces() {
+		size := diskUsage(workspace)
+		if flags.dryRun {
+			sh.Echo(fmt.Sprintf("Would remove %s (%s)", workspace, formatSize(size)))
+		} else {
+			// NOTE: Workspaces which are in use are skipped instead of waiting
+			lock, holders, err := tryLockWorkspace(workspace, true)
+			if err != nil {
+				sh.Stderr("warning: failed to lock '" + workspace + "': " + err.Error() + "\n")
+				continue
+			} else if lock == nil {
+				sh.Stderr("Skipped " + workspace + ", which is in use by " + holders + "\n")
+				continue
+			}
+			err = removeWorkspace(workspace)
+			lock.Unlock()
+			if err != nil {
+				sh.Stderr("warning: failed to remove '" + workspace + "': " + err.Error() + "\n")
+				continue
+			}
+			sh.Echo(fmt.Sprintf("Removed %s (%s)", workspace, formatSize(size)))
+		}
+		freed += size
+		removed++
+	}
+	printCleaned(removed, "workspace", freed, flags.dryRun)
+}
+
+func printCleaned(count int, noun string, freed int64, dryRun bool) {
+	if count != 1 {
+		noun += "s"
+	}
+	if dryRun {
+		sh.Echo(fmt.Sprintf("Would remove %d %s, freeing %s", count, noun, formatSize(freed)))
+	} else {
+		sh.Echo(fmt.Sprintf("Removed %d %s, freeing %s", count, noun, formatSize(freed)))
+	}
+}
+
+type GraphFlags struct {
+	dot, json bool
+	why       string
+}
+
+func graphProject(deps *DependencyTracker, flags GraphFlags) {
+	defer debug.TimedFunction(time.Now(), "graphProject()")
+
+	if len(flags.why) > 0 {
+		chains, err := deps.Why(flags.why)
+		if err != nil {
+			sh.Stderr("error: " + err.Error() + "\n")
+			sh.Exit(1)
+		}
+		for i, chain := range chains {
+			if i > 0 {
+				sh.Echo()
+			}
+			sh.Echo(formatChain(chain))
+		}
+		return
+	}
+
+	nodes := deps.Graph()
+	switch {
+	case flags.json:
+		data, err := json.MarshalIndent(nodes, "", "  ")
+		if err != nil {
+			sh.Stderr("error: " + err.Error() + "\n")
+			sh.Exit(1)
+		}
+		sh.Echo(string(data))
+	case flags.dot:
+		sh.Echo(FormatDot(nodes))
+	default:
+		sh.Echo(FormatTree(nodes))
+	}
+}
+
+type CacheFlags struct {
+	days int
+	all  bool
+}
+
+func manageCache(action string, flags CacheFlags) {
+	defer debug.TimedFunction(time.Now(), "manageCache("+action+")")
+
+	entries := listCache()
+	switch action {
+	case "list":
+		var total int64
+		for _, entry := range entries {
+			size := diskUsage(entry.path)
+			total += size
+			name := entry.info.Repository
+			if entry.kind == "snapshot" {
+				name = entry.info.Protocol + " " + name + " @ " + formatRevision(LockedDependency{Revision: entry.info.Revision})
+			}
+			sh.Echo(fmt.Sprintf("%-8s  %10s  %s  %s", entry.kind, formatSize(size), entry.lastUsed.Format("2006-01-02"), name))
+		}
+		sh.Echo(fmt.Sprintf("%d entries, %s in %s", len(entries), formatSize(total), cacheDir()))
+
+	case "verify":
+		failed := 0
+		for _, entry := range entries {
+			if err := verifyCacheEntry(entry); err != nil {
+				sh.Stderr(fmt.Sprintf("error: %s %s: %s\n", entry.kind, entry.path, strings.TrimSpace(err.Error())))
+				failed++
+			}
+		}
+		if failed > 0 {
+			sh.Stderr(fmt.Sprintf("%d of %d entries failed to verify (remove them with 'bottle cache prune --all')\n", failed, len(entries)))
+			sh.Exit(1)
+		}
+		sh.Echo(fmt.Sprintf("%d entries verified", len(entries)))
+
+	case "prune":
+		var freed int64
+		removed := 0
+		cutoff := time.Now().AddDate(0, 0, -flags.days)
+		for _, entry := range entries {
+			if flags.all || entry.lastUsed.Before(cutoff) {
+				// NOTE: Entries which are in use are skipped instead of waiting
+				unlock, err := tryLockCacheEntry(entry.path)
+				if err != nil {
+					sh.Stderr("warning: failed to lock '" + entry.path + "': " + err.Error() + "\n")
+					continue
+				} else if unlock == nil {
+					sh.Stderr("Skipped " + entry.path + ", which is in use\n")
+					continue
+				}
+				freed += diskUsage(entry.path)
+				removeCacheEntry(entry)
+				unlock()
+				removed++
+			}
+		}
+		sh.Echo(fmt.Sprintf("Removed %d entries, freeing %s", removed, formatSize(freed)))
+
+	default:
+		sh.Stderr("error: unknown cache action '" + action + "' (expected list, verify or prune)\n")
+		sh.Exit(1)
 	}
 }
 
@@ -150,7 +818,10 @@ func publishProject(cfg *Config, flags PublishFlags) {
 	}
 
 	// Copy the changes into the public repository
-	sh.Cmd(`rsync`, `-rum`, cfg.Project+"/", pubdir).Run()
+	if _, err := filesync.Sync(cfg.Project, pubdir, filesync.Options{Update: true}); err != nil {
+		sh.Stderr("error: can't copy the project to the public repository: " + err.Error() + "\n")
+		sh.Exit(1)
+	}
 
 	// Stage the changes in the repository
 	cmd = sh.Cmd(`git`, `add`, `.`)
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The [dependencies] of a Bottle.toml are edited line by line (instead of
+// decoding and encoding the whole file), so that the rest of the file keeps
+// its comments, ordering, and formatting.
+//
+// A dependency is either a key in the [dependencies] table, which is an
+// inline table on a single line:
+//
+//     [dependencies]
+//     "github.com/a/b" = { git = "https://github.com/a/b.git" }
+//
+// or a subtable, which continues until the next table header:
+//
+//     [dependencies."github.com/a/b"]
+//     git = "https://github.com/a/b.git"
+
+// dependencyField is a key of a dependency, with its value as TOML.
+type dependencyField struct {
+	key, value string
+}
+
+// dependencyGroups are the keys of which a dependency has at most one, so
+// setting one of them removes the others (eg. "--tag" replaces a "branch").
+var dependencyGroups = [][]string{
+	{"path", "git", "hg", "svn", "bzr", "protocol", "source"},
+	{"rev", "tag", "branch", "version"},
+}
+
+// dependencyFields returns the keys which are set in a dependency.
+func dependencyFields(meta configDependency) []dependencyField {
+	var fields []dependencyField
+	for _, field := range []dependencyField{
+		{"path", meta.Path}, {"git", meta.Git}, {"hg", meta.Hg}, {"svn", meta.Svn}, {"bzr", meta.Bzr},
+		{"protocol", meta.Protocol}, {"source", meta.Source},
+		{"rev", meta.Rev}, {"tag", meta.Tag}, {"branch", meta.Branch}, {"version", meta.Version},
+		{"as", meta.As},
+	} {
+		if len(field.value) > 0 {
+			fields = append(fields, dependencyField{field.key, strconv.Quote(field.value)})
+		}
+	}
+	if meta.Install {
+		fields = append(fields, dependencyField{"install", "true"})
+	}
+	return fields
+}
+
+// formatDependency returns the line of a dependency in the [dependencies]
+// table.
+func formatDependency(importPath string, fields []dependencyField) string {
+	return strconv.Quote(importPath) + " = " + formatInlineTable(fields)
+}
+
+func formatInlineTable(fields []dependencyField) string {
+	if len(fields) == 0 {
+		return "{}"
+	}
+	var values []string
+	for _, field := range fields {
+		values = append(values, field.key+" = "+field.value)
+	}
+	return "{ " + strings.Join(values, ", ") + " }"
+}
+
+// mergeFields sets keys of a dependency, and removes the keys which conflict
+// with them.  The other keys keep their values and their order.
+func mergeFields(existing, fields []dependencyField) []dependencyField {
+	var merged []dependencyField
+	for _, field := range existing {
+		if !replacesField(fields, field.key) {
+			merged = append(merged, field)
+		}
+	}
+	for _, field := range fields {
+		replaced := false
+		for i := range merged {
+			if merged[i].key == field.key {
+				merged[i].value = field.value
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, field)
+		}
+	}
+	return merged
+}
+
+// replacesField returns whether setting the fields removes an existing key,
+// because one of the fields is another key in the same group.
+func replacesField(fields []dependencyField, key string) bool {
+	for _, group := range dependencyGroups {
+		if !containsString(group, key) {
+			continue
+		}
+		for _, field := range fields {
+			if field.key != key && containsString(group, field.key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// setDependency adds a dependency to a config, or sets the keys of the
+// existing entry of the dependency.  An existing entry keeps its other keys
+// and its comments.
+func setDependency(data []byte, importPath string, fields []dependencyField) []byte {
+	lines := strings.Split(string(data), "\n")
+	if start, end, ok := findDependency(lines, importPath); ok {
+		if isTableHeader(lines[start]) {
+			lines = setSubtableFields(lines, start, end, fields)
+		} else if line, ok := setInlineFields(lines[start], fields); ok {
+			lines[start] = line
+		} else {
+			lines[start] = formatDependency(importPath, fields)
+		}
+		return []byte(strings.Join(lines, "\n"))
+	}
+
+	line := formatDependency(importPath, fields)
+	header, insert := findDependenciesTable(lines)
+	if header < 0 {
+		// NOTE: The file ends with a newline, which is an empty last line
+		for len(lines) > 0 && len(strings.TrimSpace(lines[len(lines)-1])) == 0 {
+			lines = lines[:len(lines)-1]
+		}
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "[dependencies]", line, "")
+		return []byte(strings.Join(lines, "\n"))
+	}
+
+	lines = append(lines[:insert], append([]string{line}, lines[insert:]...)...)
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// setInlineFields sets keys in a `"import/path" = { ... }` line, or returns
+// false if the line isn't an inline table.
+func setInlineFields(line string, fields []dependencyField) (string, bool) {
+	start, ok := lineValue(line)
+	if !ok {
+		return "", false
+	}
+	existing, n, ok := parseInlineTable(line[start:])
+	if !ok {
+		return "", false
+	}
+	return line[:start] + formatInlineTable(mergeFields(existing, fields)) + line[start+n:], true
+}
+
+// setSubtableFields sets keys in the lines of a `[dependencies."x"]` subtable,
+// which start with its header and end before "end".  New keys are added
+// after the last key of the subtable.
+func setSubtableFields(lines []string, start, end int, fields []dependencyField) []string {
+	set := make(map[string]bool)
+	var kept []string
+	insert := 0
+	for _, line := range lines[start+1 : end] {
+		key, ok := parseLineKey(line)
+		if ok && replacesField(fields, key) {
+			continue
+		}
+		for _, field := range fields {
+			if ok && field.key == key {
+				line = setLineValue(line, field.value)
+				set[key] = true
+			}
+		}
+		kept = append(kept, line)
+		if !isBlankOrComment(line) {
+			insert = len(kept)
+		}
+	}
+
+	var added []string
+	for _, field := range fields {
+		if !set[field.key] {
+			added = append(added, field.key+" = "+field.value)
+		}
+	}
+	table := append([]string{lines[start]}, kept[:insert]...)
+	table = append(table, added...)
+	table = append(table, kept[insert:]...)
+	return append(lines[:start], append(table, lines[end:]...)...)
+}
+
+// setLineValue replaces the value of a "key = value" line, and keeps the
+// rest of the line (eg. a comment).
+func setLineValue(line, value string) string {
+	start, _ := lineValue(line)
+	n, ok := parseValue(line[start:])
+	if !ok {
+		return line[:start] + value
+	}
+	return line[:start] + value + line[start+n:]
+}
+
+// removeDependency removes the entry of a dependency from a config, or
+// returns false if the config doesn't have the dependency.
+func removeDependency(data []byte, importPath string) ([]byte, bool) {
+	lines := strings.Split(string(data), "\n")
+	start, end, ok := findDependency(lines, importPath)
+	if !ok {
+		return data, false
+	}
+	lines = append(lines[:start], lines[end:]...)
+	return []byte(strings.Join(lines, "\n")), true
+}
+
+// findDependency returns the lines of a dependency's entry.  The blank lines
+// and comments at the end of a subtable are left for the next table.
+func findDependency(lines []string, importPath string) (int, int, bool) {
+	table := ""
+	for i, line := range lines {
+		if name, ok := parseTableHeader(line); ok {
+			table = name
+			if name == "dependencies."+strconv.Quote(importPath) {
+				end := nextTableHeader(lines, i+1)
+				for end > i+1 && isBlankOrComment(lines[end-1]) {
+					end -= 1
+				}
+				return i, end, true
+			}
+			continue
+		}
+		if table == "dependencies" {
+			if key, ok := parseLineKey(line); ok && key == importPath {
+				return i, i + 1, true
+			}
+		}
+	}
+	return -1, -1, false
+}
+
+// findDependenciesTable returns the header of the [dependencies] table and
+// the line after its last key, or -1 if the config doesn't have the table.
+func findDependenciesTable(lines []string) (int, int) {
+	for i, line := range lines {
+		if name, ok := parseTableHeader(line); ok && name == "dependencies" {
+			insert := i + 1
+			for j := i + 1; j < nextTableHeader(lines, i+1); j++ {
+				if !isBlankOrComment(lines[j]) {
+					insert = j + 1
+				}
+			}
+			return i, insert
+		}
+	}
+	return -1, -1
+}
+
+func nextTableHeader(lines []string, start int) int {
+	for i := start; i < len(lines); i++ {
+		if isTableHeader(lines[i]) {
+			return i
+		}
+	}
+	return len(lines)
+}
+
+func isTableHeader(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "[")
+}
+
+func isBlankOrComment(line string) bool {
+	line = strings.TrimSpace(line)
+	return len(line) == 0 || strings.HasPrefix(line, "#")
+}
+
+// parseTableHeader returns the name of the table in a "[table]" line, with
+// its keys separated by dots and each key after the first quoted (eg.
+// `dependencies."github.com/a/b"`).  Array tables ("[[bin]]") are ignored.
+func parseTableHeader(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "[") || strings.HasPrefix(line, "[[") {
+		return "", false
+	}
+
+	var keys []string
+	rest := strings.TrimSpace(line[1:])
+	for {
+		key, n, ok := parseKey(rest)
+		if !ok {
+			return "", false
+		}
+		if len(keys) > 0 {
+			key = strconv.Quote(key)
+		}
+		keys = append(keys, key)
+
+		rest = strings.TrimSpace(rest[n:])
+		if strings.HasPrefix(rest, "]") {
+			return strings.Join(keys, "."), true
+		} else if !strings.HasPrefix(rest, ".") {
+			return "", false
+		}
+		rest = strings.TrimSpace(rest[1:])
+	}
+}
+
+// lineValue returns where the value of a "key = value" line starts.
+func lineValue(line string) (int, bool) {
+	start := skipSpaces(line, 0)
+	_, n, ok := parseKey(line[start:])
+	if !ok {
+		return 0, false
+	}
+	i := skipSpaces(line, start+n)
+	if !strings.HasPrefix(line[i:], "=") {
+		return 0, false
+	}
+	return skipSpaces(line, i+1), true
+}
+
+// parseLineKey returns the key of a "key = value" line.
+func parseLineKey(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	key, n, ok := parseKey(line)
+	if !ok || !strings.HasPrefix(strings.TrimSpace(line[n:]), "=") {
+		return "", false
+	}
+	return key, true
+}
+
+// parseKey parses a bare, quoted, or literal key at the start of a string,
+// and returns the key and its length in the string.
+func parseKey(s string) (string, int, bool) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i += 1
+			} else if s[i] == '"' {
+				key, err := strconv.Unquote(s[:i+1])
+				return key, i + 1, err == nil
+			}
+		}
+		return "", 0, false
+	case strings.HasPrefix(s, "'"):
+		if i := strings.Index(s[1:], "'"); i >= 0 {
+			return s[1 : i+1], i + 2, true
+		}
+		return "", 0, false
+	default:
+		i := 0
+		for i < len(s) && (s[i] >= 'A' && s[i] <= 'Z' || s[i] >= 'a' && s[i] <= 'z' || s[i] >= '0' && s[i] <= '9' || s[i] == '_' || s[i] == '-') {
+			i += 1
+		}
+		return s[:i], i, i > 0
+	}
+}
+
+// parseInlineTable parses an inline table ("{ a = 1, b = 2 }") at the start
+// of a string, and returns its keys (with their values as TOML) and its
+// length in the string.
+func parseInlineTable(s string) ([]dependencyField, int, bool) {
+	if !strings.HasPrefix(s, "{") {
+		return nil, 0, false
+	}
+	var fields []dependencyField
+	i := skipSpaces(s, 1)
+	if strings.HasPrefix(s[i:], "}") {
+		return nil, i + 1, true
+	}
+	for {
+		key, n, ok := parseKey(s[i:])
+		if !ok {
+			return nil, 0, false
+		}
+		i = skipSpaces(s, i+n)
+		if !strings.HasPrefix(s[i:], "=") {
+			return nil, 0, false
+		}
+		i = skipSpaces(s, i+1)
+		n, ok = parseValue(s[i:])
+		if !ok {
+			return nil, 0, false
+		}
+		fields = append(fields, dependencyField{key, s[i : i+n]})
+		i = skipSpaces(s, i+n)
+
+		switch {
+		case strings.HasPrefix(s[i:], ","):
+			i = skipSpaces(s, i+1)
+		case strings.HasPrefix(s[i:], "}"):
+			return fields, i + 1, true
+		default:
+			return nil, 0, false
+		}
+	}
+}
+
+// parseValue returns the length of the value at the start of a string: a
+// string, an inline table or array, or a bare value (eg. "true").
+func parseValue(s string) (int, bool) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\'':
+			end, ok := stringEnd(s, i)
+			if !ok {
+				return 0, false
+			} else if depth == 0 {
+				return end, true
+			}
+			i = end - 1
+		case c == '{' || c == '[':
+			depth += 1
+		case c == '}' || c == ']':
+			if depth == 0 {
+				return i, i > 0
+			}
+			depth -= 1
+			if depth == 0 {
+				return i + 1, true
+			}
+		case depth == 0 && (c == ',' || c == ' ' || c == '\t' || c == '#'):
+			return i, i > 0
+		}
+	}
+	return len(s), depth == 0 && len(s) > 0
+}
+
+// stringEnd returns the end of the basic or literal string at s[i].
+func stringEnd(s string, i int) (int, bool) {
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		if quote == '"' && s[j] == '\\' {
+			j += 1
+		} else if s[j] == quote {
+			return j + 1, true
+		}
+	}
+	return 0, false
+}
+
+func skipSpaces(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i += 1
+	}
+	return i
+}
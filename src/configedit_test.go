@@ -0,0 +1,151 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSetDependency(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		meta   configDependency
+		want   string
+	}{
+		{
+			"inline entry",
+			`[package]
+name = "app"
+
+[dependencies]
+"github.com/a/b" = { git = "x", install = true } # keep me
+"github.com/c/d" = {}
+`,
+			configDependency{Tag: "v1"},
+			`[package]
+name = "app"
+
+[dependencies]
+"github.com/a/b" = { git = "x", install = true, tag = "v1" } # keep me
+"github.com/c/d" = {}
+`,
+		},
+		{
+			"inline entry with a conflicting key",
+			`[dependencies]
+"github.com/a/b" = { git = "x", branch = "dev", as = "github.com/me/b" }
+`,
+			configDependency{Path: "../b", Rev: "abc"},
+			`[dependencies]
+"github.com/a/b" = { as = "github.com/me/b", path = "../b", rev = "abc" }
+`,
+		},
+		{
+			"inline entry with punctuation in its values",
+			`[dependencies]
+'github.com/a/b' = {source="x, y = {z}",tag="v\"1"}   # keep me
+`,
+			configDependency{Branch: "dev"},
+			`[dependencies]
+'github.com/a/b' = { source = "x, y = {z}", branch = "dev" }   # keep me
+`,
+		},
+		{
+			"subtable",
+			`[dependencies."github.com/a/b"]
+git = "x" # the fork
+branch = "dev"
+# the version is pinned below
+
+[[bin]]
+name = "app"
+`,
+			configDependency{Git: "y", Tag: "v1", Install: true},
+			`[dependencies."github.com/a/b"]
+git = "y" # the fork
+tag = "v1"
+install = true
+# the version is pinned below
+
+[[bin]]
+name = "app"
+`,
+		},
+		{
+			"missing [dependencies] table",
+			`# My project
+[package]
+name = "app"
+
+`,
+			configDependency{Git: "x"},
+			`# My project
+[package]
+name = "app"
+
+[dependencies]
+"github.com/a/b" = { git = "x" }
+`,
+		},
+		{
+			"new entry after comments and blank lines",
+			`[dependencies]
+# The logger
+"github.com/c/d" = {} # unpinned
+
+# "github.com/e/f" = {}
+
+[[bin]]
+name = "app"
+`,
+			configDependency{Version: "^1.2"},
+			`[dependencies]
+# The logger
+"github.com/c/d" = {} # unpinned
+"github.com/a/b" = { version = "^1.2" }
+
+# "github.com/e/f" = {}
+
+[[bin]]
+name = "app"
+`,
+		},
+	}
+	for _, test := range tests {
+		got := string(setDependency([]byte(test.config), "github.com/a/b", dependencyFields(test.meta)))
+		if got != test.want {
+			t.Errorf("%s: setDependency =>\n%s\nwant:\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRemoveDependency(t *testing.T) {
+	config := `[dependencies]
+"github.com/a/b" = { git = "x" } # remove me
+"github.com/c/d" = {}
+
+[dependencies."github.com/e/f"]
+git = "y"
+
+# The binaries
+[[bin]]
+name = "app"
+`
+	want := `[dependencies]
+"github.com/c/d" = {}
+
+
+# The binaries
+[[bin]]
+name = "app"
+`
+	data, ok := removeDependency([]byte(config), "github.com/a/b")
+	if ok {
+		data, ok = removeDependency(data, "github.com/e/f")
+	}
+	if !ok || string(data) != want {
+		t.Errorf("removeDependency => %v,\n%s\nwant:\n%s", ok, data, want)
+	}
+	if _, ok := removeDependency(data, "github.com/x/y"); ok {
+		t.Errorf(`removeDependency => true; want false for a missing dependency`)
+	}
+}
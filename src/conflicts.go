@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"bottle/semver"
+	"bottle/shutil"
+)
+
+// ImportConflictError is returned when two packages depend on the same import
+// path from different sources (or at conflicting revisions), and the root
+// config doesn't choose one.
+type ImportConflictError struct {
+	ImportPath  string
+	Existing    conflictingSource // the source which was added first
+	Conflicting conflictingSource
+	Config      string // the root config file, where the conflict can be fixed
+}
+
+type conflictingSource struct {
+	Dependency Dependency
+	Revision   Revision
+	Chain      []string // the import paths from the root package to the dependency
+	Original   string   // the import path in the config, if the dependency was renamed
+}
+
+func (err *ImportConflictError) Error() string {
+	var lines []string
+	for _, source := range []conflictingSource{err.Existing, err.Conflicting} {
+		description := source.Dependency.Protocol + " " + source.Dependency.Repository
+		if selector := source.Revision.String(); len(selector) > 0 {
+			description += " (" + selector + ")"
+		}
+		lines = append(lines, description, "    required by "+strings.Join(source.Chain, " -> "))
+	}
+
+	conflict := "sources"
+	if err.Existing.Dependency == err.Conflicting.Dependency {
+		conflict = "revisions"
+	}
+	return fmt.Sprintf("Conflicting %s for import path \"%s\":\n\n\t%s\n\n"+
+		"Choose one by adding it to the [dependencies] in \"%s\", for example:\n\n\t%s\n",
+		conflict, err.ImportPath, strings.Join(lines, "\n\t"), err.Config, err.Suggestion())
+}
+
+// Suggestion returns a config entry which selects the existing source.
+func (err *ImportConflictError) Suggestion() string {
+	dep, rev := err.Existing.Dependency, err.Existing.Revision
+
+	key := err.ImportPath
+	meta := configDependency{Rev: rev.Rev, Tag: rev.Tag, Branch: rev.Branch, Version: rev.Version}
+	switch dep.Protocol {
+	case "path":
+		meta.Path = filepath.ToSlash(shutil.Relpath(shutil.Dirname(err.Config), dep.Repository))
+	case "git":
+		meta.Git = dep.Repository
+	case "hg":
+		meta.Hg = dep.Repository
+	case "svn":
+		meta.Svn = dep.Repository
+	case "bzr":
+		meta.Bzr = dep.Repository
+	case "go-get":
+		// NOTE: The import path is the source
+	default:
+		meta.Protocol, meta.Source = dep.Protocol, dep.Repository
+	}
+	if len(dep.Alias) > 0 {
+		key, meta.As = err.Existing.Original, dep.Alias
+	}
+	return formatConfigEntry(key, meta)
+}
+
+// formatConfigEntry formats a dependency in the same way as it would be
+// written in a Bottle.toml.
+func formatConfigEntry(key string, meta configDependency) string {
+	var fields []string
+	for _, field := range []struct{ key, value string }{
+		{"path", meta.Path}, {"git", meta.Git}, {"hg", meta.Hg}, {"svn", meta.Svn}, {"bzr", meta.Bzr},
+		{"protocol", meta.Protocol}, {"source", meta.Source},
+		{"rev", meta.Rev}, {"tag", meta.Tag}, {"branch", meta.Branch}, {"version", meta.Version},
+		{"as", meta.As},
+	} {
+		if len(field.value) > 0 {
+			fields = append(fields, field.key+" = "+strconv.Quote(field.value))
+		}
+	}
+	if meta.Install {
+		fields = append(fields, "install = true")
+	}
+
+	if len(fields) == 0 {
+		return strconv.Quote(key) + " = {}"
+	}
+	return strconv.Quote(key) + " = { " + strings.Join(fields, ", ") + " }"
+}
+
+// conflictingRevision returns a revision of a dependency which was required by
+// another package, and that package, if it conflicts with the revision which
+// an importer requires.  Two different commits, tags or branches conflict,
+// and so do version constraints which no version can satisfy (or a tag which
+// doesn't satisfy a version constraint).  The default branch doesn't conflict
+// with anything.
+func (deps *DependencyTracker) conflictingRevision(dep Dependency, rev Revision, importer string) (Revision, string, bool) {
+	existing := deps.requested[dep]
+	first := deps.importedBy[deps.canonicalPaths[dep]]
+	if first == importer || len(existing.String()) == 0 || len(rev.String()) == 0 {
+		return Revision{}, "", false
+	}
+
+	switch {
+	case len(existing.Version) > 0:
+		// NOTE: Each constraint is compared with every package's requirement
+		for _, requirement := range deps.requirements[dep] {
+			if requirement.importer == importer {
+				continue
+			}
+			constraint, err := semver.ParseConstraint(requirement.constraint)
+			if err != nil {
+				continue // NOTE: reported when the version is selected
+			}
+			if !satisfiesRevision(constraint, rev) {
+				return Revision{Version: requirement.constraint}, requirement.importer, true
+			}
+		}
+	case len(rev.Version) > 0:
+		constraint, err := semver.ParseConstraint(rev.Version)
+		if err == nil && !satisfiesRevision(constraint, existing) {
+			return existing, first, true
+		}
+	case existing != rev:
+		return existing, first, true
+	}
+	return Revision{}, "", false
+}
+
+// satisfiesRevision returns whether a revision may satisfy a version
+// constraint.  A commit or a branch could be any version.
+func satisfiesRevision(constraint *semver.Constraint, rev Revision) bool {
+	switch {
+	case len(rev.Version) > 0:
+		other, err := semver.ParseConstraint(rev.Version)
+		return err != nil || constraint.Intersects(other)
+	case len(rev.Tag) > 0:
+		version, err := semver.Parse(rev.Tag)
+		return err != nil || constraint.Check(version)
+	}
+	return true
+}
+
+// importChain returns the import paths of the packages which led to a
+// package being added, starting from the root package.
+func (deps *DependencyTracker) importChain(importPath string) []string {
+	chain := []string{importPath}
+	for importPath != deps.rootConfig.Package.Name {
+		importer, ok := deps.importedBy[importPath]
+		if !ok || len(chain) > len(deps.importedBy) {
+			break // NOTE: this shouldn't happen, but avoids an infinite loop
+		}
+		chain = append([]string{importer}, chain...)
+		importPath = importer
+	}
+	return chain
+}
+
+// checkConflict decides what to do when a package depends on an import path
+// which is already used by a different source, or on an existing dependency
+// at a revision which conflicts with the revision required by another package.
+// The root config always wins, so that it can override the sources and
+// revisions chosen by any other package; otherwise the conflict is an error.
+// Two dependencies in the root config with the same import path (eg. because
+// of "as") are an error too.
+func (deps *DependencyTracker) checkConflict(importPath, original string, dep Dependency, rev Revision, importer string) error {
+	root := deps.rootConfig.Package.Name
+	if importPath == root || (deps.importedBy[importPath] == root && importer != root) {
+		return nil
+	}
+
+	var existing Dependency
+	for other, canonical := range deps.canonicalPaths {
+		if canonical == importPath {
+			existing = other
+			break
+		}
+	}
+	if existing == dep {
+		existingRev, existingImporter, ok := deps.conflictingRevision(dep, rev, importer)
+		if !ok {
+			return nil
+		}
+		return &ImportConflictError{
+			ImportPath: importPath,
+			Existing: conflictingSource{
+				Dependency: dep,
+				Revision:   existingRev,
+				Chain:      append(deps.importChain(existingImporter), importPath),
+				Original:   deps.renamedFrom[dep],
+			},
+			Conflicting: conflictingSource{
+				Dependency: dep,
+				Revision:   rev,
+				Chain:      append(deps.importChain(importer), importPath),
+				Original:   original,
+			},
+			Config: shutil.Path(deps.rootConfig.Project, "Bottle.toml"),
+		}
+	}
+	if importer == root {
+		return fmt.Errorf("Two dependencies in \"%s\" have the import path \"%s\":\n\n\t%s %s\n\t%s %s\n",
+			shutil.Path(deps.rootConfig.Project, "Bottle.toml"), importPath,
+			existing.Protocol, existing.Repository, dep.Protocol, dep.Repository)
+	}
+	return &ImportConflictError{
+		ImportPath: importPath,
+		Existing: conflictingSource{
+			Dependency: existing,
+			Revision:   deps.requested[existing],
+			Chain:      deps.importChain(importPath),
+			Original:   deps.renamedFrom[existing],
+		},
+		Conflicting: conflictingSource{
+			Dependency: dep,
+			Revision:   rev,
+			Chain:      append(deps.importChain(importer), importPath),
+			Original:   original,
+		},
+		Config: shutil.Path(deps.rootConfig.Project, "Bottle.toml"),
+	}
+}
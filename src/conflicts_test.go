@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// newConflictTracker returns a tracker for a project which depends on the
+// packages "example.com/a" and "example.com/b", and the configs of those
+// packages, which each depend on "example.com/lib" at a revision.
+func newConflictTracker(t *testing.T, root, a, b configDependency) (*DependencyTracker, *Config, *Config) {
+	cfg := &Config{Project: "/src/app"}
+	cfg.Package.Name = "example.com/app"
+	cfg.Package.Root = "/src/app"
+	cfg.Dependencies = map[string]configDependency{
+		"example.com/a": {Path: "../a"},
+		"example.com/b": {Path: "../b"},
+	}
+	if len(root.revision().String()) > 0 {
+		cfg.Dependencies["example.com/lib"] = root
+	}
+	deps, err := NewDependencyTracker(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var configs []*Config
+	for _, meta := range []configDependency{a, b} {
+		meta.Git = "https://example.com/lib.git"
+		configs = append(configs, &Config{Dependencies: map[string]configDependency{"example.com/lib": meta}})
+	}
+	return deps, configs[0], configs[1]
+}
+
+func TestConflictingRevisions(t *testing.T) {
+	tests := []struct {
+		name       string
+		root, a, b configDependency
+		conflict   bool
+	}{
+		{"disjoint versions", configDependency{}, configDependency{Version: "^1.2"}, configDependency{Version: ">=2.0"}, true},
+		{"overlapping versions", configDependency{}, configDependency{Version: "^1.2"}, configDependency{Version: "^1.4"}, false},
+		{"different tags", configDependency{}, configDependency{Tag: "v1.0.0"}, configDependency{Tag: "v1.1.0"}, true},
+		{"different branch and commit", configDependency{}, configDependency{Branch: "dev"}, configDependency{Rev: "0123abc"}, true},
+		{"tag outside of a version", configDependency{}, configDependency{Version: "^1"}, configDependency{Tag: "v2.0.0"}, true},
+		{"tag inside of a version", configDependency{}, configDependency{Tag: "v1.3.0"}, configDependency{Version: "^1.2"}, false},
+		{"default branch", configDependency{}, configDependency{}, configDependency{Tag: "v1.0.0"}, false},
+		{"chosen by the root config", configDependency{Git: "https://example.com/lib.git", Tag: "v1.0.0"},
+			configDependency{Version: "^1"}, configDependency{Version: "^2"}, false},
+	}
+	for _, test := range tests {
+		deps, a, b := newConflictTracker(t, test.root, test.a, test.b)
+		if err := deps.addPackage(a, "example.com/a"); err != nil {
+			t.Fatalf("%s: addPackage(a) => %s", test.name, err)
+		}
+		err := deps.addPackage(b, "example.com/b")
+		if !test.conflict {
+			if err != nil {
+				t.Errorf("%s: addPackage(b) => %s; want nil", test.name, err)
+			}
+			continue
+		}
+
+		conflict, ok := err.(*ImportConflictError)
+		if !ok {
+			t.Errorf("%s: addPackage(b) => %v; want an ImportConflictError", test.name, err)
+			continue
+		}
+		existing := strings.Join(conflict.Existing.Chain, " -> ")
+		conflicting := strings.Join(conflict.Conflicting.Chain, " -> ")
+		if existing != "example.com/app -> example.com/a -> example.com/lib" ||
+			conflicting != "example.com/app -> example.com/b -> example.com/lib" {
+			t.Errorf("%s: conflict between %s and %s; want both importers", test.name, existing, conflicting)
+		}
+		if !strings.HasPrefix(err.Error(), `Conflicting revisions for import path "example.com/lib"`) {
+			t.Errorf("%s: error:\n%s", test.name, err)
+		}
+	}
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"os"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
 	"bottle/debug"
+	"bottle/semver"
 	"bottle/shutil"
 )
 
@@ -17,6 +20,9 @@ type DependencyTracker struct {
 	usedImports    map[string]bool
 	updatedImports map[string]bool // excludes "AlreadyResolved" dependencies
 	canonicalPaths map[Dependency]string
+	importedBy     map[string]string // the package which first depended on each import path
+	renamedFrom    map[Dependency]string
+	edges          []dependencyEdge // every dependency in every package's config
 
 	installPackages map[string]bool
 	packagePrefixes map[string]string //  (eg. github.com/a/b/cmd/x to github.com/a/b)
@@ -24,64 +30,169 @@ type DependencyTracker struct {
 	resolved   map[Dependency]bool
 	unresolved []Dependency
 
+	requested map[Dependency]Revision         // revisions selected by the config
+	pins      map[Dependency]LockedDependency // revisions read from the lockfile
+	revisions map[Dependency]string           // revisions which are in the workspace
+
+	requirements map[Dependency][]versionRequirement
+	versions     map[Dependency]string // tags selected to satisfy the requirements
+
+	updateAll   bool
+	updatePaths map[string]bool // import paths which should ignore the lockfile
+
 	needsFallback []string
+	missing       []*OfflineError // packages which couldn't be resolved offline
+	patched       map[string]bool // patches in the root config which were used
 }
 
 type Dependency struct {
-	Protocol   string // "fallback", "go-get", "path", "git" (TODO: "hg", "bzr", "svn")
+	Protocol   string // "fallback", "go-get", "path", "git", "hg", "svn", "bzr" (or a plugin's protocol)
 	Repository string
+	Alias      string // the "as" import path, so a renamed copy is a separate dependency
 }
 
-func NewDependencyTracker(cfg *Config) *DependencyTracker {
+// NewDependencyTracker returns a tracker of the dependencies of a root config,
+// or an error if the config's dependencies conflict with each other.
+func NewDependencyTracker(cfg *Config) (*DependencyTracker, error) {
 	deps := &DependencyTracker{
 		usedImports:    make(map[string]bool),
 		updatedImports: make(map[string]bool),
 		canonicalPaths: make(map[Dependency]string),
+		importedBy:     make(map[string]string),
+		renamedFrom:    make(map[Dependency]string),
 
 		installPackages: make(map[string]bool),
 		packagePrefixes: make(map[string]string),
 
 		resolved: make(map[Dependency]bool),
+
+		requested: make(map[Dependency]Revision),
+		pins:      make(map[Dependency]LockedDependency),
+		revisions: make(map[Dependency]string),
+
+		requirements: make(map[Dependency][]versionRequirement),
+		versions:     make(map[Dependency]string),
+
+		updatePaths: make(map[string]bool),
+		patched:     make(map[string]bool),
 	}
 	deps.rootConfig = cfg
 	dep := Dependency{Protocol: "path", Repository: cfg.Package.Root}
 	deps.canonicalPaths[dep] = cfg.Package.Name
 	deps.usedImports[cfg.Package.Name] = true
-	deps.addPackage(cfg)
-	return deps
+	if err := deps.addPackage(cfg, cfg.Package.Name); err != nil {
+		return nil, err // NOTE: two dependencies have the same import path
+	}
+	return deps, nil
+}
+
+// versionRequirement is a version constraint, and the package which needs it.
+type versionRequirement struct {
+	constraint string
+	importer   string
 }
 
 type resolveResult struct {
-	dep Dependency
-	err error
+	dep     Dependency
+	rev     string
+	version string
+	err     error
+}
+
+// ResolveError lists every dependency which failed to be resolved.
+type ResolveError struct {
+	Failures []*DependencyError
+	Missing  []*OfflineError // dependencies which couldn't be resolved offline
+}
+
+// DependencyError is the reason that a dependency failed to be resolved.
+type DependencyError struct {
+	ImportPath string
+	Dependency Dependency
+	Err        error
+}
+
+func (err *ResolveError) Error() string {
+	var sections []string
+	for _, failure := range err.Failures {
+		message := strings.TrimSpace(failure.Err.Error())
+		sections = append(sections, fmt.Sprintf("%s (%s %s):\n\n\t%s",
+			failure.ImportPath, failure.Dependency.Protocol, failure.Dependency.Repository,
+			strings.Join(strings.Split(message, "\n"), "\n\t")))
+	}
+	message := fmt.Sprintf("Failed to resolve %d dependencies:\n\n%s\n", len(err.Failures), strings.Join(sections, "\n\n"))
+	if len(err.Missing) > 0 {
+		message += "\n" + missingError(err.Missing).Error()
+	}
+	return message
 }
 
-func (deps *DependencyTracker) ResolveAll() error {
+// ResolveAll resolves every dependency in the graph.  A dependency which fails
+// doesn't stop the other dependencies from being resolved, and every failure
+// is returned together.  If the context is cancelled, the running resolvers
+// are stopped, and it returns after they have exited.
+//
+// At most "jobs" resolvers run at the same time, and their progress is shown
+// on stderr.
+func (deps *DependencyTracker) ResolveAll(ctx context.Context) error {
 	defer debug.TimedFunction(time.Now(), "DependencyTracker.ResolveAll()")
 
+	status := startProgress(os.Stderr)
+	defer status.Stop()
+	limit := make(chan struct{}, resolveJobs())
+
+	var failures []*DependencyError
+	fail := func(dep Dependency, err error) {
+		failures = append(failures, &DependencyError{ImportPath: deps.canonicalPaths[dep], Dependency: dep, Err: err})
+	}
+
 	var results []chan resolveResult
 	for len(deps.unresolved) > 0 || len(results) > 0 {
 
-		// Start a go routine for each dependency
+		// Start a go routine for each dependency (unless resolving was cancelled)
 		for _, dep := range deps.unresolved {
-			if !deps.resolved[dep] {
+			if !deps.resolved[dep] && ctx.Err() == nil {
 				deps.resolved[dep] = true
 
 				// Lookup arguments for the resolver
 				importPath := deps.canonicalPaths[dep]
-				resolver, exists := builtinResolvers[dep.Protocol]
-				if !exists {
-					// FIXME: Cancel or wait for any running go-routines
-					return fmt.Errorf(`No resolver exists for protocol "%s"`, dep.Protocol)
+				resolver, err := findResolver(dep.Protocol)
+				if err != nil {
+					fail(dep, err)
+					continue
 				}
 
 				// Download the dependency asynchronously
-				result := make(chan resolveResult)
+				result := make(chan resolveResult, 1)
 				results = append(results, result)
-				go func(ch chan resolveResult, fn ResolverFunc, dep Dependency, path string) {
-					err := fn(dep.Repository, path, deps.rootConfig.Workspace)
-					ch <- resolveResult{dep: dep, err: err}
-				}(result, resolver, dep, importPath)
+				rev, version := deps.revision(dep)
+				requirements := append([]versionRequirement(nil), deps.requirements[dep]...)
+				go func(ch chan resolveResult, fn ResolverFunc, dep Dependency, rev Revision, version string, path string) {
+					select {
+					case limit <- struct{}{}:
+						defer func() { <-limit }()
+					case <-ctx.Done():
+						ch <- resolveResult{dep: dep, err: ctx.Err()}
+						return
+					}
+
+					task := status.Start(path, dep.Protocol+" "+dep.Repository)
+					result := func() resolveResult {
+						if len(rev.Version) > 0 {
+							tag, err := selectVersion(ctx, dep, requirements)
+							if err != nil {
+								return resolveResult{dep: dep, err: err}
+							}
+							rev, version = Revision{Tag: tag}, tag
+						}
+						commit, err := fn(ctx, dep.Repository, rev, path, deps.rootConfig.Workspace)
+						return resolveResult{dep: dep, rev: commit, version: version, err: err}
+					}()
+
+					// NOTE: "path" dependencies are copied every time, so they aren't reported
+					status.Finish(task, result.err == nil && dep.Protocol != "path")
+					ch <- result
+				}(result, resolver, dep, rev, version, importPath)
 			}
 		}
 		deps.unresolved = nil
@@ -94,12 +205,8 @@ func (deps *DependencyTracker) ResolveAll() error {
 			{
 				result := <-results[0]
 				results = results[1:]
-				if result.err == AlreadyResolved {
-					// Do nothing!
-				} else if result.err != nil {
-					return result.err // FIXME: Cancel or wait for any running go-routines
-				} else {
-					deps.loadPackage(result.dep)
+				if err := deps.loadResult(result); err != nil {
+					fail(result.dep, err)
 				}
 			}
 
@@ -111,12 +218,8 @@ func (deps *DependencyTracker) ResolveAll() error {
 				case result := <-ch: // if this result is ready
 					resultsCompleted += 1
 
-					if result.err == AlreadyResolved {
-						// Do nothing!
-					} else if result.err != nil {
-						return result.err // FIXME: Cancel or wait for any running go-routines
-					} else {
-						deps.loadPackage(result.dep)
+					if err := deps.loadResult(result); err != nil {
+						fail(result.dep, err)
 					}
 
 				default: // if this results is NOT ready
@@ -127,15 +230,37 @@ func (deps *DependencyTracker) ResolveAll() error {
 		}
 	}
 
+	// NOTE: The errors of cancelled resolvers are just from being stopped
+	if ctx.Err() != nil {
+		return fmt.Errorf("Stopped resolving dependencies: %s\n", context.Cause(ctx))
+	}
+	if len(failures) == 1 && len(deps.missing) == 0 {
+		return failures[0].Err
+	} else if len(failures) > 0 {
+		sort.Slice(failures, func(i, j int) bool { return failures[i].ImportPath < failures[j].ImportPath })
+		return &ResolveError{Failures: failures, Missing: deps.missing}
+	}
+
 	// Use the go tool to fetch non-Bottle dependencies with "go get" without
 	// adding any more branches to the dependency tree
 	for _, importPath := range deps.needsFallback {
 		start := time.Now()
 
-		cmd := shutil.Cmd(`go`, `get`, `-d`, importPath)
+		// When offline, check that the packages are in the workspace instead
+		if offline {
+			deps.missing = append(deps.missing, deps.listMissingPackages(importPath)...)
+			continue
+		}
+
+		task := status.Start(importPath, "go get")
+		cmd := shutil.CmdContext(ctx, `go`, `get`, `-d`, importPath)
 		cmd.Env = append([]string{"GOPATH=" + deps.rootConfig.Workspace}, cmd.Env...)
 		cmd.Dir = deps.rootConfig.Workspace
 		output, err := cmd.Try()
+		status.Finish(task, false)
+		if ctx.Err() != nil {
+			return fmt.Errorf("Stopped resolving dependencies: %s\n", context.Cause(ctx))
+		}
 		if err != nil {
 			tabbedOutput := strings.Join(strings.Split(output, "\n"), "\n\t")
 			return fmt.Errorf("Failed fetching \"%s\" dependencies with \"go get\":\n\n\t%s\n", importPath, tabbedOutput)
@@ -144,9 +269,204 @@ func (deps *DependencyTracker) ResolveAll() error {
 		debug.TimedFunction(start, "block { go get -d "+importPath+" }")
 	}
 
+	if len(deps.missing) > 0 {
+		return missingError(deps.missing)
+	}
 	return nil
 }
 
+// listMissingPackages returns the packages imported by a non-Bottle dependency
+// which are not in the workspace.
+func (deps *DependencyTracker) listMissingPackages(importPath string) []*OfflineError {
+	cmd := shutil.Cmd(`go`, `list`, `-e`, `-deps`, `-f`, `{{if .Error}}{{.ImportPath}}{{end}}`, importPath)
+	cmd.Env = append([]string{"GOPATH=" + deps.rootConfig.Workspace}, cmd.Env...)
+	cmd.Dir = deps.rootConfig.Workspace
+	output, err := cmd.Try()
+	if err != nil {
+		return []*OfflineError{{Package: importPath, Reason: strings.TrimSpace(output)}}
+	}
+
+	var missing []*OfflineError
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if len(line) > 0 {
+			missing = append(missing, &OfflineError{Package: line, Reason: "the package is not in the workspace (imported by " + importPath + ")"})
+		}
+	}
+	return missing
+}
+
+func (deps *DependencyTracker) loadResult(result resolveResult) error {
+	// Report every package which is missing when offline, instead of only the first
+	if missing, ok := result.err.(*OfflineError); ok {
+		missing.Package = fmt.Sprintf("%s (%s %s)", deps.canonicalPaths[result.dep], result.dep.Protocol, result.dep.Repository)
+		deps.missing = append(deps.missing, missing)
+		return nil
+	}
+	if result.err != nil && result.err != AlreadyResolved {
+		return result.err
+	}
+	deps.revisions[result.dep] = result.rev
+	if len(result.version) > 0 {
+		deps.versions[result.dep] = result.version
+
+		// Resolve the dependency again if a requirement was added while it was
+		// being resolved, which isn't satisfied by the selected version.
+		if !deps.satisfiesRequirements(result.dep, result.version) {
+			deps.reresolve(result.dep)
+			return nil
+		}
+	}
+
+	return deps.loadPackage(result.dep, result.err != AlreadyResolved)
+}
+
+// revision returns the revision of a dependency that should be resolved, which
+// is the pinned revision unless the config has requested a different one.  If
+// the pinned revision was selected by a version constraint, it also returns
+// the pinned version.
+func (deps *DependencyTracker) revision(dep Dependency) (Revision, string) {
+	requested := deps.requested[dep]
+	if deps.shouldUpdate(dep) {
+		requested.Refresh = true
+		return requested, ""
+	}
+
+	pin, pinned := deps.pins[dep]
+	if len(requested.Version) > 0 {
+		if pinned && len(pin.Version) > 0 && deps.satisfiesRequirements(dep, pin.Version) {
+			return Revision{Rev: pin.Revision}, pin.Version
+		}
+		return requested, ""
+	}
+	if pinned && pin.Requested == requested.String() {
+		return Revision{Rev: pin.Revision}, ""
+	}
+	return requested, ""
+}
+
+// Update makes the tracker ignore the lockfile and fetch the latest revision
+// allowed by the config for the dependencies with the given import paths, or
+// for every dependency if no import paths are given.
+func (deps *DependencyTracker) Update(importPaths []string) {
+	if len(importPaths) == 0 {
+		deps.updateAll = true
+	}
+	for _, importPath := range importPaths {
+		deps.updatePaths[importPath] = true
+	}
+}
+
+func (deps *DependencyTracker) shouldUpdate(dep Dependency) bool {
+	if deps.updateAll {
+		return true
+	}
+
+	// NOTE: The import path may be a subpackage of the dependency
+	canonical := deps.canonicalPaths[dep]
+	for importPath := range deps.updatePaths {
+		if importPath == canonical || strings.HasPrefix(importPath, canonical+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// requireVersion adds a version constraint to a dependency.  If a different
+// version has already been selected, the dependency will be resolved again.
+func (deps *DependencyTracker) requireVersion(dep Dependency, constraint string, importer string) {
+	if len(deps.requested[dep].Version) == 0 {
+		return // a specific revision was already requested by another package
+	}
+
+	requirement := versionRequirement{constraint: constraint, importer: importer}
+	for _, existing := range deps.requirements[dep] {
+		if existing == requirement {
+			return
+		}
+	}
+	deps.requirements[dep] = append(deps.requirements[dep], requirement)
+
+	if version, ok := deps.versions[dep]; ok && !deps.satisfiesRequirements(dep, version) {
+		deps.reresolve(dep)
+	}
+}
+
+func (deps *DependencyTracker) reresolve(dep Dependency) {
+	delete(deps.versions, dep)
+	deps.resolved[dep] = false
+	deps.unresolved = append(deps.unresolved, dep)
+}
+
+func (deps *DependencyTracker) satisfiesRequirements(dep Dependency, tag string) bool {
+	version, err := semver.Parse(tag)
+	if err != nil {
+		return false
+	}
+	for _, requirement := range deps.requirements[dep] {
+		constraint, err := semver.ParseConstraint(requirement.constraint)
+		if err != nil || !constraint.Check(version) {
+			return false
+		}
+	}
+	return true
+}
+
+// selectVersion lists the tags of a dependency's repository and returns the
+// highest tag which satisfies all of the version requirements.
+func selectVersion(ctx context.Context, dep Dependency, requirements []versionRequirement) (string, error) {
+	var constraints []*semver.Constraint
+	for _, requirement := range requirements {
+		constraint, err := semver.ParseConstraint(requirement.constraint)
+		if err != nil {
+			return "", err
+		}
+		constraints = append(constraints, constraint)
+	}
+
+	tags, err := listTags(ctx, dep)
+	if err != nil {
+		return "", err
+	}
+
+	// NOTE: The versions which satisfy all but the first constraint are
+	//       candidates, and the first constraint picks the highest of them
+	var available, candidates []string
+	var versions []semver.Version
+	for _, tag := range tags {
+		version, err := semver.Parse(tag)
+		if err != nil {
+			continue // ignore tags which aren't versions
+		}
+		available = append(available, tag)
+
+		satisfied := true
+		for i := 1; i < len(constraints); i++ {
+			satisfied = satisfied && constraints[i].Check(version)
+		}
+		if satisfied {
+			candidates = append(candidates, tag)
+			versions = append(versions, version)
+		}
+	}
+
+	best := -1
+	if len(constraints) > 0 {
+		best = constraints[0].Highest(versions)
+	}
+	if best < 0 {
+		var lines []string
+		for _, requirement := range requirements {
+			lines = append(lines, fmt.Sprintf("%q required by %s", requirement.constraint, requirement.importer))
+		}
+		if len(available) == 0 {
+			available = append(available, "(none)")
+		}
+		return "", fmt.Errorf("No version of \"%s\" satisfies all of its requirements:\n\n\t%s\n\nAvailable versions: %s\n",
+			dep.Repository, strings.Join(lines, "\n\t"), strings.Join(available, ", "))
+	}
+	return candidates[best], nil
+}
+
 func (deps *DependencyTracker) InstallAll() error {
 	defer debug.TimedFunction(time.Now(), "DependencyTracker.InstallAll()")
 
@@ -168,15 +488,32 @@ func (deps *DependencyTracker) InstallAll() error {
 	return nil
 }
 
-func (deps *DependencyTracker) loadPackage(dep Dependency) {
+// PathDependencies returns the source directory of each "path" dependency in
+// the workspace, by import path.
+func (deps *DependencyTracker) PathDependencies() map[string]string {
+	dirs := make(map[string]string)
+	for dep := range deps.revisions {
+		if dep.Protocol == "path" {
+			dirs[deps.canonicalPaths[dep]] = dep.Repository
+		}
+	}
+	return dirs
+}
+
+// loadPackage adds the dependencies of a resolved package to the tracker.  The
+// dependencies of packages which were already in the workspace are still
+// loaded, so that every dependency is recorded in the lockfile.
+func (deps *DependencyTracker) loadPackage(dep Dependency, updated bool) error {
 	importPath := deps.canonicalPaths[dep]
-	deps.updatedImports[importPath] = true
+	if updated {
+		deps.updatedImports[importPath] = true
+	}
 
 	// Find the new package's dependencies
 	dest := shutil.Path(deps.rootConfig.Workspace, "src", importPath)
 	cfg, err := discoverPackage(dest, shutil.Path(dest, "Bottle.toml"), false)
 	if err != nil {
-		log.Fatalf("Failed to find package in \"%s\":\n\n\t%s\n\n", dest, err.Error())
+		return fmt.Errorf("Failed to find package in \"%s\":\n\n\t%s\n", dest, err.Error())
 	}
 
 	// HACK: This overrides the project directory set by discoverPackage in
@@ -185,73 +522,131 @@ func (deps *DependencyTracker) loadPackage(dep Dependency) {
 		cfg.Project = dep.Repository
 	}
 
-	{
-		//
-		// TODO / FIXME : Apply import renames
-		//
+	// Rewrite the imports of renamed dependencies in the workspace copy
+	renames := configRenames(cfg)
+	if original, ok := deps.renamedFrom[dep]; ok {
+		renames[original] = importPath
+	}
+	if _, err := applyRenames(dest, renames); err != nil {
+		return err
 	}
 
-	deps.addPackage(cfg)
+	return deps.addPackage(cfg, importPath)
 }
 
-func (deps *DependencyTracker) addPackage(cfg *Config) {
-	if cfg.Missing {
+// addPackage adds the dependencies in a package's config to the tracker.  It
+// returns an error if one of the import paths is already used by a different
+// source, unless the root config chose that source.
+func (deps *DependencyTracker) addPackage(cfg *Config, importer string) error {
+	if cfg.Missing && len(cfg.Module) == 0 {
 		if !shutil.Exists(shutil.Path(cfg.Package.Root, "vendor")) {
 			deps.needsFallback = append(deps.needsFallback, cfg.ImportPath+"/...")
 		}
 	}
 
 	// TODO: maybe sort config dependencies before iterating?
-	for importPath, meta := range cfg.Dependencies {
-		packagePath := importPath
-
-		// Parse (and normalize) the package's source from the config
-		var dep Dependency
-		switch {
-		case len(meta.Path) > 0:
-			dep.Repository = shutil.Abspath(shutil.Path(cfg.Project, meta.Path))
-			dep.Protocol = "path"
-		case len(meta.Git) > 0:
-			dep.Repository = meta.Git
-			dep.Protocol = "git"
-		default:
-			if strings.HasPrefix(importPath, "bitbucket.org") {
-				importPath = parseImportPrefix(importPath)
-				dep.Repository = "https://" + importPath + ".git"
-				dep.Protocol = "git"
-			} else if strings.HasPrefix(importPath, "github.com") {
-				importPath = parseImportPrefix(importPath)
-				dep.Repository = "https://" + importPath + ".git"
-				dep.Protocol = "git"
-			} else {
-				dep.Repository = importPath
-				dep.Protocol = "go-get"
-			}
+	for key, meta := range cfg.Dependencies {
+		dep, importPath := parseDependency(cfg.Project, key, meta)
+		packagePath, rev, constraint := key, meta.revision(), meta.Version
+
+		// Use the root config's source for a patched dependency, wherever it is
+		if patched, patch, ok := deps.findPatch(importPath); ok {
+			dep, importPath = parseDependency(deps.rootConfig.Project, patched, patch)
+			rev, constraint = patch.revision(), patch.Version
 		}
 
-		// Skip the package if we've already added it
+		// Use a renamed dependency's new import path in the workspace
+		original := importPath
+		if len(meta.As) > 0 {
+			dep.Alias = meta.As
+			importPath = meta.As
+			packagePath, _ = renameImportPath(packagePath, map[string]string{original: importPath})
+		}
+
+		// Skip the package if we've already added it (at a compatible revision)
 		if canonical, ok := deps.canonicalPaths[dep]; ok {
+			if err := deps.checkConflict(canonical, original, dep, rev, importer); err != nil {
+				return err
+			}
+			deps.addEdge(cfg, importer, key, meta, canonical)
 			if meta.Install {
 				deps.installPackages[canonical] = true
 			}
+			if len(constraint) > 0 {
+				deps.requireVersion(dep, constraint, importer)
+			}
 			continue
 		}
 
 		// Check that this import path isn't already in use
 		if deps.usedImports[importPath] {
-			// TODO: return an error, with advice on how to fix the problem
-			panic("FIXME: handle duplicate import paths (" + importPath + ")")
+			if err := deps.checkConflict(importPath, original, dep, rev, importer); err != nil {
+				return err
+			}
+			deps.addEdge(cfg, importer, key, meta, importPath)
+			continue
 		}
 
 		// Add a new unresolved dependency and register this import path as the canonical path
 		deps.unresolved = append(deps.unresolved, dep)
 		deps.canonicalPaths[dep] = importPath
 		deps.usedImports[importPath] = true
+		deps.importedBy[importPath] = importer
+		deps.addEdge(cfg, importer, key, meta, importPath)
+		deps.requested[dep] = rev
+		if len(dep.Alias) > 0 {
+			deps.renamedFrom[dep] = original
+		}
+		if len(constraint) > 0 {
+			deps.requireVersion(dep, constraint, importer)
+		}
 		if meta.Install {
 			deps.installPackages[packagePath] = true
 			deps.packagePrefixes[packagePath] = importPath
 		}
 	}
+	return nil
+}
+
+// parseDependency returns the source of a dependency in a config, and the
+// import path of the repository (which is the config's import path, unless
+// the source is inferred from a prefix like "github.com").
+func parseDependency(project, importPath string, meta configDependency) (Dependency, string) {
+	var dep Dependency
+	switch {
+	case len(meta.Path) > 0:
+		dep.Repository = shutil.Abspath(shutil.Path(project, meta.Path))
+		dep.Protocol = "path"
+	case len(meta.Git) > 0:
+		dep.Repository = meta.Git
+		dep.Protocol = "git"
+	case len(meta.Hg) > 0:
+		dep.Repository = meta.Hg
+		dep.Protocol = "hg"
+	case len(meta.Svn) > 0:
+		dep.Repository = meta.Svn
+		dep.Protocol = "svn"
+	case len(meta.Bzr) > 0:
+		dep.Repository = meta.Bzr
+		dep.Protocol = "bzr"
+	case len(meta.Protocol) > 0:
+		dep.Repository = meta.Source
+		dep.Protocol = meta.Protocol
+	default:
+		if strings.HasPrefix(importPath, "bitbucket.org") {
+			importPath = parseImportPrefix(importPath)
+			dep.Repository = "https://" + importPath + ".git"
+			dep.Protocol = "git"
+		} else if strings.HasPrefix(importPath, "github.com") {
+			importPath = parseImportPrefix(importPath)
+			dep.Repository = "https://" + importPath + ".git"
+			dep.Protocol = "git"
+		} else {
+			dep.Repository = importPath
+			dep.Protocol = "go-get"
+		}
+	}
+	return dep, importPath
 }
 
 func parseImportPrefix(importPath string) string {
@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"path"
 	"strconv"
+	"strings"
 
+	"bottle/gomod"
+	"bottle/semver"
 	"bottle/shutil"
 	"bottle/toml"
 )
@@ -16,6 +18,7 @@ type Config struct {
 
 	Missing    bool   `toml:"-"` // whether the project contains a config file
 	ImportPath string `toml:"-"` // set if Missing is true
+	Module     string `toml:"-"` // set if Missing is true and the project contains a "go.mod"
 
 	Package struct {
 		Name    string // name of the project's root package (required)
@@ -28,11 +31,14 @@ type Config struct {
 
 		Publish bool // whether the package is intended to be published
 
-		Exclude []string // exclude these files copying the package to the workspace
-		Root    string   // the source directory of this project's root package, if not the project directory
+		Exclude   []string // exclude these files copying the package to the workspace (see exclude.go)
+		Gitignore bool     // also exclude the files ignored by the package's ".gitignore" files
+		Root      string   // the source directory of this project's root package, if not the project directory
+		Workspace string   // the directory of the project's workspace, if not a temporary directory
 	}
 
 	Dependencies map[string]configDependency
+	Patch        map[string]configDependency // replace the source of a dependency anywhere in the graph (see patch.go)
 
 	Bin []struct {
 		Name string
@@ -45,8 +51,25 @@ type configDependency struct {
 
 	Path string
 	Git  string
-	// TODO: Hg string
-	// TODO: Svn string
+	Hg   string
+	Svn  string
+	Bzr  string
+
+	// Resolve the dependency with a custom resolver (see "bottle help resolvers")
+	Protocol string
+	Source   string
+
+	// Select a specific revision of the dependency (at most one may be set)
+	Rev     string
+	Tag     string
+	Branch  string
+	Version string // a semantic version constraint (eg. "^1.2") matched against tags
+
+	As string // use the dependency with a different import path (see rename.go)
+}
+
+func (meta configDependency) revision() Revision {
+	return Revision{Rev: meta.Rev, Tag: meta.Tag, Branch: meta.Branch, Version: meta.Version}
 }
 
 func discoverPackage(mayberoot, maybecfg string, searchParents bool) (*Config, error) {
@@ -70,15 +93,29 @@ func discoverPackage(mayberoot, maybecfg string, searchParents bool) (*Config, e
 		if err != nil {
 			return nil, err
 		}
+		err = validateDependencies(cfg)
+		if err != nil {
+			return nil, fmt.Errorf(`discoverPackage: in "%s": %s`, shutil.Abspath(cfgpath), err)
+		}
 	} else {
 		cfg.Missing = true
 		cfg.ImportPath = shutil.Relpath(shutil.Path(env["GOPATH"], "src"), shutil.Abspath(pkgroot))
 		cfg.Package.Name = path.Base(cfg.ImportPath)
+
+		// Use the requirements of a Go module as its dependencies
+		modpath := shutil.Path(pkgroot, "go.mod")
+		if shutil.IsRegularFile(modpath) {
+			mod, err := gomod.Parse(shutil.Binread(modpath))
+			if err != nil {
+				return nil, fmt.Errorf(`discoverPackage: in "%s": %s`, shutil.Abspath(modpath), err)
+			}
+			loadGoMod(cfg, mod)
+		}
 	}
 
 	// Set directories to absolute paths
 	cfg.Project = shutil.Abspath(pkgroot)
-	cfg.Workspace = shutil.Path(os.TempDir(), "bottle", cfg.Package.Name)
+	cfg.Workspace = workspaceDir(cfg)
 	if len(cfg.Package.Root) > 0 {
 		// TODO: enforce that the package root is a subdirectory of "cfg.Project"
 		cfg.Package.Root = shutil.Abspath(shutil.Path(pkgroot, cfg.Package.Root))
@@ -89,6 +126,71 @@ func discoverPackage(mayberoot, maybecfg string, searchParents bool) (*Config, e
 	return cfg, nil
 }
 
+func validateDependencies(cfg *Config) error {
+	for _, line := range cfg.Package.Exclude {
+		if _, _, err := parseExcludePattern("", line); err != nil {
+			return err
+		}
+	}
+	for importPath, meta := range cfg.Dependencies {
+		if err := validateDependency("dependency", importPath, meta); err != nil {
+			return err
+		}
+	}
+	for importPath, meta := range cfg.Patch {
+		if err := validateDependency("patch", importPath, meta); err != nil {
+			return err
+		}
+		if len(meta.Path) == 0 && len(meta.Git) == 0 && len(meta.Hg) == 0 && len(meta.Svn) == 0 && len(meta.Bzr) == 0 && len(meta.Protocol) == 0 {
+			return fmt.Errorf(`patch "%s" must have one of "path", "git", "hg", "svn", "bzr", or "protocol"`, importPath)
+		}
+		if meta.Install || len(meta.As) > 0 {
+			return fmt.Errorf(`patch "%s" can't have "install" or "as" (set them in [dependencies] instead)`, importPath)
+		}
+	}
+	return nil
+}
+
+func validateDependency(kind, importPath string, meta configDependency) error {
+	sources := 0
+	for _, source := range []string{meta.Path, meta.Git, meta.Hg, meta.Svn, meta.Bzr, meta.Protocol} {
+		if len(source) > 0 {
+			sources += 1
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf(`%s "%s" can only have one of "path", "git", "hg", "svn", "bzr", or "protocol"`, kind, importPath)
+	}
+	if len(meta.Protocol) > 0 && len(meta.Source) == 0 {
+		return fmt.Errorf(`%s "%s" has a "protocol" but no "source"`, kind, importPath)
+	}
+	if len(meta.Source) > 0 && len(meta.Protocol) == 0 {
+		return fmt.Errorf(`%s "%s" has a "source" but no "protocol"`, kind, importPath)
+	}
+
+	selectors := 0
+	for _, selector := range []string{meta.Rev, meta.Tag, meta.Branch, meta.Version} {
+		if len(selector) > 0 {
+			selectors += 1
+		}
+	}
+	if selectors > 1 {
+		return fmt.Errorf(`%s "%s" can only have one of "rev", "tag", "branch", or "version"`, kind, importPath)
+	}
+	if selectors > 0 && len(meta.Path) > 0 {
+		return fmt.Errorf(`%s "%s" has a "path" and can't select a revision`, kind, importPath)
+	}
+	if len(meta.Version) > 0 {
+		if _, err := semver.ParseConstraint(meta.Version); err != nil {
+			return fmt.Errorf(`%s "%s" has an invalid version: %s`, kind, importPath, err)
+		}
+	}
+	if len(meta.As) > 0 && (meta.As == importPath || strings.ContainsAny(meta.As, " \t\"\\")) {
+		return fmt.Errorf(`%s "%s" has an invalid "as" path "%s"`, kind, importPath, meta.As)
+	}
+	return nil
+}
+
 // findNearestConfig file searches for a Bottle.toml config file by checking
 // the current directory and then each parent directory in the path.
 func findNearestConfig(mayberoot, maybecfg string) (string, string) {
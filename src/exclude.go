@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"bottle/shutil"
+	"bottle/toml"
+)
+
+// Files are excluded from the copy of a package in the workspace by the
+// "exclude" patterns in the [package] of its Bottle.toml, and optionally by
+// its ".gitignore" files.  Hidden files are always excluded.
+//
+// The patterns have the same syntax as ".gitignore":
+//
+//     testdata       matches "testdata" in any directory
+//     /testdata      matches "testdata" in the package's root directory
+//     assets/gen     matches "gen" in the root's "assets" directory
+//     *.min.js       matches with wildcards ("*", "?", and "[a-z]")
+//     docs/**/*.png  matches "**" with any number of directories
+//     node_modules/  matches only directories
+//     !keep.go       includes a file excluded by an earlier pattern
+//
+// Later patterns take precedence, and the patterns in Bottle.toml take
+// precedence over ".gitignore".  A file can't be included if one of its
+// parent directories is excluded.
+
+type excludePattern struct {
+	base     string   // the directory of the ".gitignore" containing the pattern
+	segments []string // the parts of the pattern separated by "/"
+	anchored bool     // whether the pattern matches from "base" (instead of any directory)
+	dirOnly  bool     // whether the pattern only matches directories
+	negated  bool     // whether the pattern includes the paths it matches
+}
+
+// parseExcludePattern parses a pattern, or returns false if the line is blank
+// or a comment.
+func parseExcludePattern(base, line string) (excludePattern, bool, error) {
+	original := line
+	line = strings.TrimRight(line, " \t\r")
+	if len(line) == 0 || strings.HasPrefix(line, "#") {
+		return excludePattern{}, false, nil
+	}
+
+	pattern := excludePattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		pattern.negated = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:] // NOTE: escapes a leading "!" or "#"
+	}
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		pattern.anchored = true
+		line = strings.TrimLeft(line, "/")
+	}
+	if len(line) == 0 {
+		return excludePattern{}, false, fmt.Errorf(`invalid exclude pattern "%s"`, original)
+	}
+
+	pattern.segments = strings.Split(line, "/")
+	for _, segment := range pattern.segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return excludePattern{}, false, fmt.Errorf(`invalid exclude pattern "%s": %s`, original, err)
+		}
+	}
+	return pattern, true, nil
+}
+
+// matches returns whether the pattern matches a path relative to the
+// package's root directory.
+func (pattern excludePattern) matches(rel string, isDir bool) bool {
+	if pattern.dirOnly && !isDir {
+		return false
+	}
+	if len(pattern.base) > 0 {
+		if !strings.HasPrefix(rel, pattern.base+"/") {
+			return false
+		}
+		rel = rel[len(pattern.base)+1:]
+	}
+
+	parts := strings.Split(rel, "/")
+	if !pattern.anchored {
+		return matchSegments(pattern.segments, parts[len(parts)-1:])
+	}
+	return matchSegments(pattern.segments, parts)
+}
+
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if len(pattern) == 1 && pattern[0] == "**" {
+		return len(parts) > 0 // NOTE: "a/**" matches the contents of "a", but not "a"
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], parts[1:])
+}
+
+// excludeList decides which files of a package are copied to the workspace.
+type excludeList struct {
+	root      string
+	patterns  []excludePattern
+	gitignore bool
+
+	ignored map[string][]excludePattern // the patterns of each ".gitignore" read so far, by directory
+}
+
+func newExcludeList(root string, patterns []string, gitignore bool) (*excludeList, error) {
+	list := &excludeList{root: root, gitignore: gitignore, ignored: make(map[string][]excludePattern)}
+	for _, line := range patterns {
+		pattern, ok, err := parseExcludePattern("", line)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			list.patterns = append(list.patterns, pattern)
+		}
+	}
+	return list, nil
+}
+
+// packageExcludes returns the files excluded from a "path" dependency by the
+// Bottle.toml in its directory, if it has one.
+func packageExcludes(dir string) (*excludeList, error) {
+	cfg := new(Config)
+	cfgpath := shutil.Path(dir, "Bottle.toml")
+	if shutil.Exists(cfgpath) {
+		if err := toml.Unmarshal(shutil.Binread(cfgpath), cfg); err != nil {
+			return nil, fmt.Errorf(`in "%s": %s`, cfgpath, err)
+		}
+	}
+	return newExcludeList(dir, cfg.Package.Exclude, cfg.Package.Gitignore)
+}
+
+// Exclude returns whether a path (relative to the package's root directory)
+// is excluded.
+func (list *excludeList) Exclude(rel string, isDir bool) bool {
+	if isHidden(rel, isDir) {
+		return true
+	}
+
+	excluded := false
+	if list.gitignore {
+		// NOTE: Each ".gitignore" from the root down to the path's directory
+		//       takes precedence over the ones in its parents.
+		dirs := []string{""}
+		if dir := path.Dir(rel); dir != "." {
+			parts := strings.Split(dir, "/")
+			for i := range parts {
+				dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+			}
+		}
+		for _, dir := range dirs {
+			for _, pattern := range list.readGitignore(dir) {
+				if pattern.matches(rel, isDir) {
+					excluded = !pattern.negated
+				}
+			}
+		}
+	}
+	for _, pattern := range list.patterns {
+		if pattern.matches(rel, isDir) {
+			excluded = !pattern.negated
+		}
+	}
+	return excluded
+}
+
+// readGitignore returns the patterns in the ".gitignore" of a directory.
+// Invalid patterns are ignored, as they are by git.
+func (list *excludeList) readGitignore(dir string) []excludePattern {
+	if patterns, ok := list.ignored[dir]; ok {
+		return patterns
+	}
+
+	var patterns []excludePattern
+	data, err := ioutil.ReadFile(shutil.Path(list.root, dir, ".gitignore"))
+	if err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if pattern, ok, err := parseExcludePattern(dir, line); err == nil && ok {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	list.ignored[dir] = patterns
+	return patterns
+}
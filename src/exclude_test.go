@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExclude(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		{[]string{"testdata"}, "testdata", true, true},
+		{[]string{"testdata"}, "a/b/testdata", true, true},
+		{[]string{"testdata"}, "testdata.go", false, false},
+		{[]string{"/testdata"}, "testdata", true, true},
+		{[]string{"/testdata"}, "a/testdata", true, false},
+		{[]string{"assets/gen"}, "assets/gen", true, true},
+		{[]string{"assets/gen"}, "a/assets/gen", true, false},
+		{[]string{"*.min.js"}, "static/app.min.js", false, true},
+		{[]string{"*.min.js"}, "static/app.js", false, false},
+		{[]string{"?.go"}, "a.go", false, true},
+		{[]string{"[a-c].go"}, "d.go", false, false},
+		{[]string{"node_modules/"}, "node_modules", true, true},
+		{[]string{"node_modules/"}, "node_modules", false, false},
+		{[]string{"docs/**/*.png"}, "docs/a.png", false, true},
+		{[]string{"docs/**/*.png"}, "docs/a/b/c.png", false, true},
+		{[]string{"docs/**/*.png"}, "src/a.png", false, false},
+		{[]string{"**/gen"}, "gen", true, true},
+		{[]string{"**/gen"}, "a/b/gen", true, true},
+		{[]string{"a/**"}, "a", true, false},
+		{[]string{"a/**"}, "a/b", false, true},
+		{[]string{"a/**"}, "a/b/c", false, true},
+		{[]string{"*.js", "!keep.js"}, "keep.js", false, false},
+		{[]string{"*.js", "!keep.js"}, "other.js", false, true},
+		{[]string{"!keep.js", "*.js"}, "keep.js", false, true},
+		{[]string{`\!important`}, "!important", false, true},
+		{[]string{"# comment", ""}, "# comment", false, false},
+		{nil, ".git", true, true},
+		{nil, "a/.hidden", false, true},
+	}
+	for _, test := range tests {
+		list, err := newExcludeList("", test.patterns, false)
+		if err != nil {
+			t.Errorf(`newExcludeList(%q) => %s`, test.patterns, err)
+			continue
+		}
+		if got := list.Exclude(test.path, test.isDir); got != test.want {
+			t.Errorf(`Exclude(%q, %v) with %q => %v; want %v`, test.path, test.isDir, test.patterns, got, test.want)
+		}
+	}
+}
+
+func TestExcludeInvalid(t *testing.T) {
+	for _, pattern := range []string{"/", "!", "a/[b"} {
+		if _, err := newExcludeList("", []string{pattern}, false); err == nil {
+			t.Errorf(`newExcludeList(%q) => nil; want an error`, pattern)
+		}
+	}
+}
+
+func TestExcludeGitignore(t *testing.T) {
+	root, err := ioutil.TempDir("", "exclude")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for filename, content := range map[string]string{
+		".gitignore":     "*.log\nbuild/\n",
+		"sub/.gitignore": "!keep.log\n/local\n",
+	} {
+		filename = filepath.Join(root, filepath.FromSlash(filename))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		patterns  []string
+		gitignore bool
+		path      string
+		isDir     bool
+		want      bool
+	}{
+		{nil, true, "a.log", false, true},
+		{nil, true, "build", true, true},
+		{nil, true, "sub/keep.log", false, false},
+		{nil, true, "keep.log", false, true},
+		{nil, true, "sub/local", false, true},
+		{nil, true, "local", false, false},
+		{nil, false, "a.log", false, false},
+		{[]string{"!a.log"}, true, "a.log", false, false},
+		{[]string{"sub/keep.log"}, true, "sub/keep.log", false, true},
+	}
+	for _, test := range tests {
+		list, err := newExcludeList(root, test.patterns, test.gitignore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := list.Exclude(test.path, test.isDir); got != test.want {
+			t.Errorf(`Exclude(%q, %v) with %q and gitignore %v => %v; want %v`, test.path, test.isDir, test.patterns, test.gitignore, got, test.want)
+		}
+	}
+}
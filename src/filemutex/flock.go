@@ -58,3 +58,66 @@ func (m *FileMutex) RUnlock() {
 	}
 	m.mu.RUnlock()
 }
+
+// Downgrade changes an exclusive lock to a shared lock, without letting
+// another process lock the file exclusively in between.  The mutex must then
+// be released with RUnlock.
+func (m *FileMutex) Downgrade() {
+	if err := syscall.Flock(m.fd, syscall.LOCK_SH); err != nil {
+		panic(err)
+	}
+	// NOTE: Another goroutine which shares this FileMutex could lock it
+	//       between these calls, but other FileMutexes (and processes) can't
+	m.mu.Unlock()
+	m.mu.RLock()
+}
+
+// TryLock is like Lock, but returns false instead of waiting if the file is
+// locked by another process (or by another FileMutex for the same file).
+func (m *FileMutex) TryLock() bool {
+	if !m.mu.TryLock() {
+		return false
+	}
+	if err := syscall.Flock(m.fd, syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		m.mu.Unlock()
+		if err == syscall.EWOULDBLOCK {
+			return false
+		}
+		panic(err)
+	}
+	return true
+}
+
+// TryRLock is like RLock, but returns false instead of waiting if the file is
+// locked exclusively by another process (or by another FileMutex).
+func (m *FileMutex) TryRLock() bool {
+	if !m.mu.TryRLock() {
+		return false
+	}
+	if err := syscall.Flock(m.fd, syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		m.mu.RUnlock()
+		if err == syscall.EWOULDBLOCK {
+			return false
+		}
+		panic(err)
+	}
+	return true
+}
+
+// SameFile reports whether "filename" still names the file of the mutex, which
+// it doesn't if the file was removed or replaced after the mutex was created.
+func (m *FileMutex) SameFile(filename string) bool {
+	var locked, current syscall.Stat_t
+	if err := syscall.Fstat(m.fd, &locked); err != nil {
+		return false
+	}
+	if err := syscall.Stat(filename, &current); err != nil {
+		return false
+	}
+	return locked.Dev == current.Dev && locked.Ino == current.Ino
+}
+
+// Close closes the file, which releases any lock that is held.
+func (m *FileMutex) Close() error {
+	return syscall.Close(m.fd)
+}
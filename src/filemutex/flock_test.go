@@ -0,0 +1,125 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package filemutex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filemutex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "lock")
+
+	// NOTE: Each FileMutex has its own open file, so they exclude each other
+	// in the same way as separate processes
+	a, err := New(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := New(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if !a.TryLock() {
+		t.Fatalf(`TryLock => false; want true for an unlocked file`)
+	}
+	if b.TryLock() || b.TryRLock() {
+		t.Errorf(`TryLock or TryRLock => true; want false while the file is locked`)
+	}
+	a.Unlock()
+
+	if !a.TryRLock() || !b.TryRLock() {
+		t.Fatalf(`TryRLock => false; want true for a shared lock`)
+	}
+	if a.TryLock() {
+		t.Errorf(`TryLock => true; want false while the file has a shared lock`)
+	}
+	a.RUnlock()
+	b.RUnlock()
+
+	if !b.TryLock() {
+		t.Errorf(`TryLock => false; want true after the file is unlocked`)
+	}
+	b.Unlock()
+}
+
+func TestDowngrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filemutex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "lock")
+
+	a, err := New(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := New(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	a.Lock()
+	a.Downgrade()
+	if !b.TryRLock() {
+		t.Fatalf(`TryRLock => false; want true after the lock is downgraded`)
+	}
+	if b.TryLock() {
+		t.Errorf(`TryLock => true; want false while the file has a shared lock`)
+	}
+	b.RUnlock()
+	if b.TryLock() {
+		t.Errorf(`TryLock => true; want false while the downgraded lock is held`)
+	}
+	a.RUnlock()
+
+	if !b.TryLock() {
+		t.Errorf(`TryLock => false; want true after the file is unlocked`)
+	}
+	b.Unlock()
+}
+
+func TestSameFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filemutex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "lock")
+
+	m, err := New(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if !m.SameFile(filename) {
+		t.Errorf(`SameFile => false; want true for the file of the mutex`)
+	}
+
+	os.Remove(filename)
+	if m.SameFile(filename) {
+		t.Errorf(`SameFile => true; want false after the file is removed`)
+	}
+	other, err := New(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if m.SameFile(filename) {
+		t.Errorf(`SameFile => true; want false after the file is replaced`)
+	}
+}
@@ -0,0 +1,168 @@
+package filemutex
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// FileMutex is similar to sync.RWMutex, but also synchronizes across processes.
+// This implementation is based on the LockFileEx syscall.
+type FileMutex struct {
+	mu sync.RWMutex
+	fd syscall.Handle
+}
+
+func New(filename string) (*FileMutex, error) {
+	name, err := syscall.UTF16PtrFromString(filename)
+	if err != nil {
+		return nil, fmt.Errorf(`filemutex: error opening "%s": %s`, filename, err)
+	}
+	fd, err := syscall.CreateFile(name,
+		syscall.GENERIC_READ,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, fmt.Errorf(`filemutex: error opening "%s": %s`, filename, err)
+	}
+	return &FileMutex{fd: fd}, nil
+}
+
+func lockFileEx(fd syscall.Handle, flags uint32) error {
+	var ol syscall.Overlapped
+	r1, _, err := procLockFileEx.Call(uintptr(fd), uintptr(flags), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+func unlockFileEx(fd syscall.Handle) error {
+	var ol syscall.Overlapped
+	r1, _, err := procUnlockFileEx.Call(uintptr(fd), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+func (m *FileMutex) Lock() {
+	m.mu.Lock()
+	if err := lockFileEx(m.fd, lockfileExclusiveLock); err != nil {
+		panic(err)
+	}
+}
+
+func (m *FileMutex) Unlock() {
+	if err := unlockFileEx(m.fd); err != nil {
+		panic(err)
+	}
+	m.mu.Unlock()
+}
+
+func (m *FileMutex) RLock() {
+	m.mu.RLock()
+	if err := lockFileEx(m.fd, 0); err != nil {
+		panic(err)
+	}
+}
+
+func (m *FileMutex) RUnlock() {
+	if err := unlockFileEx(m.fd); err != nil {
+		panic(err)
+	}
+	m.mu.RUnlock()
+}
+
+// Downgrade changes an exclusive lock to a shared lock, without letting
+// another process lock the file exclusively in between.  The mutex must then
+// be released with RUnlock.
+func (m *FileMutex) Downgrade() {
+	// NOTE: A handle can hold a shared lock on a region which it has locked
+	//       exclusively, and the first unlock releases the exclusive lock
+	if err := lockFileEx(m.fd, lockfileFailImmediately); err != nil {
+		panic(err)
+	}
+	if err := unlockFileEx(m.fd); err != nil {
+		panic(err)
+	}
+	m.mu.Unlock()
+	m.mu.RLock()
+}
+
+// TryLock is like Lock, but returns false instead of waiting if the file is
+// locked by another process (or by another FileMutex for the same file).
+func (m *FileMutex) TryLock() bool {
+	if !m.mu.TryLock() {
+		return false
+	}
+	if err := lockFileEx(m.fd, lockfileExclusiveLock|lockfileFailImmediately); err != nil {
+		m.mu.Unlock()
+		if err == errorLockViolation {
+			return false
+		}
+		panic(err)
+	}
+	return true
+}
+
+// TryRLock is like RLock, but returns false instead of waiting if the file is
+// locked exclusively by another process (or by another FileMutex).
+func (m *FileMutex) TryRLock() bool {
+	if !m.mu.TryRLock() {
+		return false
+	}
+	if err := lockFileEx(m.fd, lockfileFailImmediately); err != nil {
+		m.mu.RUnlock()
+		if err == errorLockViolation {
+			return false
+		}
+		panic(err)
+	}
+	return true
+}
+
+// SameFile reports whether "filename" still names the file of the mutex, which
+// it doesn't if the file was removed or replaced after the mutex was created.
+func (m *FileMutex) SameFile(filename string) bool {
+	var locked, current syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(m.fd, &locked); err != nil {
+		return false
+	}
+
+	name, err := syscall.UTF16PtrFromString(filename)
+	if err != nil {
+		return false
+	}
+	fd, err := syscall.CreateFile(name, 0,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(fd)
+	if err := syscall.GetFileInformationByHandle(fd, &current); err != nil {
+		return false
+	}
+	return locked.VolumeSerialNumber == current.VolumeSerialNumber &&
+		locked.FileIndexHigh == current.FileIndexHigh && locked.FileIndexLow == current.FileIndexLow
+}
+
+// Close closes the file, which releases any lock that is held.
+func (m *FileMutex) Close() error {
+	return syscall.CloseHandle(m.fd)
+}
@@ -0,0 +1,318 @@
+// Package filesync mirrors a directory tree into another directory, in the
+// same way as "rsync --recursive --links --prune-empty-dirs", and reports the
+// paths which were changed.
+//
+// Files are copied if their size or modification time is different (or, when
+// comparing checksums, if their contents are different), and each copied file
+// keeps the modification time of its source.  Symbolic links are copied as
+// links.  Directories are only created in the destination when they contain
+// a file or link (unless EmptyDirs is set).
+package filesync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// Op is the kind of change made to a path in the destination.
+type Op int
+
+const (
+	Added Op = iota
+	Updated
+	Deleted
+)
+
+func (op Op) String() string {
+	switch op {
+	case Added:
+		return "added"
+	case Updated:
+		return "updated"
+	case Deleted:
+		return "deleted"
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Change is a path in the destination which was changed by Sync.
+type Change struct {
+	Path string // relative to the destination, with forward slashes
+	Op   Op
+}
+
+type Options struct {
+	// Exclude returns whether a path (relative to the source or destination,
+	// with forward slashes) is excluded.  Excluded paths aren't copied, and
+	// aren't deleted from the destination.
+	Exclude func(path string, isDir bool) bool
+
+	Delete    bool // delete paths in the destination which aren't in the source
+	Update    bool // skip files which are newer in the destination
+	Checksum  bool // compare the contents of files with the same size, instead of their modification times
+	EmptyDirs bool // create the directories which don't contain a file or link too
+}
+
+// Sync copies the tree at "src" into "dest", and returns the changes which
+// were made to "dest" (in the order they were made).
+func Sync(src, dest string, opts Options) ([]Change, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf(`filesync: "%s" is not a directory`, src)
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &syncer{src: src, dest: dest, opts: opts}
+	err = s.syncDir("")
+	return s.changes, err
+}
+
+type syncer struct {
+	src, dest string
+	opts      Options
+	changes   []Change
+}
+
+func (s *syncer) excluded(rel string, isDir bool) bool {
+	return s.opts.Exclude != nil && s.opts.Exclude(rel, isDir)
+}
+
+func (s *syncer) change(rel string, op Op) {
+	s.changes = append(s.changes, Change{Path: rel, Op: op})
+}
+
+func (s *syncer) syncDir(dir string) error {
+	srcEntries, err := readDir(filepath.Join(s.src, filepath.FromSlash(dir)))
+	if err != nil {
+		return err
+	}
+	if s.opts.EmptyDirs {
+		if err := os.MkdirAll(filepath.Join(s.dest, filepath.FromSlash(dir)), 0755); err != nil {
+			return err
+		}
+	}
+	destEntries, err := readDir(filepath.Join(s.dest, filepath.FromSlash(dir)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	inSource := make(map[string]bool)
+	for _, srcInfo := range srcEntries {
+		name := srcInfo.Name()
+		rel := path.Join(dir, name)
+		if s.excluded(rel, srcInfo.IsDir()) {
+			continue
+		}
+		inSource[name] = true
+
+		destInfo, _ := findEntry(destEntries, name)
+		switch mode := srcInfo.Mode(); {
+		case mode.IsDir():
+			if destInfo != nil && !destInfo.IsDir() {
+				if err := s.remove(rel); err != nil {
+					return err
+				}
+			}
+			if err := s.syncDir(rel); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			if err := s.syncLink(rel, destInfo); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if err := s.syncFile(rel, srcInfo, destInfo); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.opts.Delete {
+		for _, destInfo := range destEntries {
+			name := destInfo.Name()
+			rel := path.Join(dir, name)
+			if !inSource[name] && !s.excluded(rel, destInfo.IsDir()) {
+				if err := s.remove(rel); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *syncer) syncFile(rel string, srcInfo, destInfo os.FileInfo) error {
+	srcPath := filepath.Join(s.src, filepath.FromSlash(rel))
+	destPath := filepath.Join(s.dest, filepath.FromSlash(rel))
+
+	op := Added
+	if destInfo != nil {
+		op = Updated
+		if destInfo.Mode().IsRegular() {
+			if s.opts.Update && destInfo.ModTime().After(srcInfo.ModTime()) {
+				return nil
+			}
+			if destInfo.Size() == srcInfo.Size() {
+				if destInfo.ModTime().Equal(srcInfo.ModTime()) {
+					return nil
+				}
+				if s.opts.Checksum {
+					same, err := sameContents(srcPath, destPath)
+					if err != nil {
+						return err
+					}
+					if same {
+						return os.Chtimes(destPath, srcInfo.ModTime(), srcInfo.ModTime())
+					}
+				}
+			}
+		} else if err := os.RemoveAll(destPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	if err := copyFile(srcPath, destPath, srcInfo); err != nil {
+		return err
+	}
+	s.change(rel, op)
+	return nil
+}
+
+func (s *syncer) syncLink(rel string, destInfo os.FileInfo) error {
+	srcPath := filepath.Join(s.src, filepath.FromSlash(rel))
+	destPath := filepath.Join(s.dest, filepath.FromSlash(rel))
+
+	target, err := os.Readlink(srcPath)
+	if err != nil {
+		return err
+	}
+
+	op := Added
+	if destInfo != nil {
+		op = Updated
+		if destInfo.Mode()&os.ModeSymlink != 0 {
+			if existing, err := os.Readlink(destPath); err == nil && existing == target {
+				return nil
+			}
+		}
+		if err := os.RemoveAll(destPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Symlink(target, destPath); err != nil {
+		return err
+	}
+	s.change(rel, op)
+	return nil
+}
+
+func (s *syncer) remove(rel string) error {
+	if err := os.RemoveAll(filepath.Join(s.dest, filepath.FromSlash(rel))); err != nil {
+		return err
+	}
+	s.change(rel, Deleted)
+	return nil
+}
+
+// copyFile writes the file to a temporary file next to "dest", which is then
+// renamed, so that an interrupted copy doesn't leave a partial file.
+func copyFile(src, dest string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+".filesync")
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+func sameContents(a, b string) (bool, error) {
+	hashA, err := hashFile(a)
+	if err != nil {
+		return false, err
+	}
+	hashB, err := hashFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(hashA, hashB), nil
+}
+
+func hashFile(filename string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// readDir returns the entries of a directory, sorted by name, without
+// following symbolic links.
+func readDir(dir string) ([]os.FileInfo, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func findEntry(entries []os.FileInfo, name string) (os.FileInfo, bool) {
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Name() >= name })
+	if i < len(entries) && entries[i].Name() == name {
+		return entries[i], true
+	}
+	return nil, false
+}
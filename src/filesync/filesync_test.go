@@ -0,0 +1,173 @@
+package filesync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, filename string) string {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func tempDirs(t *testing.T) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "filesync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "src"), filepath.Join(dir, "dest"), func() { os.RemoveAll(dir) }
+}
+
+func TestSync(t *testing.T) {
+	src, dest, cleanup := tempDirs(t)
+	defer cleanup()
+
+	writeFiles(t, src, map[string]string{
+		"a.go":       "package a",
+		"sub/b.go":   "package sub",
+		".hidden":    "hidden",
+		"empty/.git": "excluded",
+	})
+	opts := Options{
+		Exclude: func(path string, isDir bool) bool { return strings.HasPrefix(filepath.Base(path), ".") },
+		Delete:  true,
+	}
+
+	changes, err := Sync(src, dest, opts)
+	if err != nil {
+		t.Fatalf(`Sync => unexpected error %v`, err)
+	}
+	expect := []Change{{"a.go", Added}, {"sub/b.go", Added}}
+	if !reflect.DeepEqual(changes, expect) {
+		t.Errorf(`Sync => %v; want %v`, changes, expect)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "empty")); !os.IsNotExist(err) {
+		t.Errorf(`Sync => created a directory without any files`)
+	}
+
+	// Nothing is copied again if the files haven't changed
+	if changes, err := Sync(src, dest, opts); err != nil || len(changes) != 0 {
+		t.Errorf(`Sync => %v, %v; want no changes`, changes, err)
+	}
+
+	// Changed files are copied, and deleted files are removed (except for
+	// files which are excluded)
+	writeFiles(t, src, map[string]string{"a.go": "package a // changed"})
+	writeFiles(t, dest, map[string]string{".keep": "excluded", "old/c.go": "package old"})
+	os.RemoveAll(filepath.Join(src, "sub"))
+	changes, err = Sync(src, dest, opts)
+	if err != nil {
+		t.Fatalf(`Sync => unexpected error %v`, err)
+	}
+	expect = []Change{{"a.go", Updated}, {"old", Deleted}, {"sub", Deleted}}
+	if !reflect.DeepEqual(changes, expect) {
+		t.Errorf(`Sync => %v; want %v`, changes, expect)
+	}
+	if content := readFile(t, filepath.Join(dest, "a.go")); content != "package a // changed" {
+		t.Errorf(`Sync => copied %q`, content)
+	}
+	if _, err := os.Stat(filepath.Join(dest, ".keep")); err != nil {
+		t.Errorf(`Sync => deleted an excluded file`)
+	}
+}
+
+func TestSyncUpdate(t *testing.T) {
+	src, dest, cleanup := tempDirs(t)
+	defer cleanup()
+
+	writeFiles(t, src, map[string]string{"a.go": "package a"})
+	writeFiles(t, dest, map[string]string{"a.go": "package a // newer"})
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(src, "a.go"), past, past)
+
+	changes, err := Sync(src, dest, Options{Update: true})
+	if err != nil || len(changes) != 0 {
+		t.Errorf(`Sync => %v, %v; want no changes`, changes, err)
+	}
+	changes, err = Sync(src, dest, Options{})
+	if err != nil || !reflect.DeepEqual(changes, []Change{{"a.go", Updated}}) {
+		t.Errorf(`Sync => %v, %v; want "a.go" to be updated`, changes, err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "a.go")); err != nil || !info.ModTime().Equal(past) {
+		t.Errorf(`Sync => expected the modification time to be copied`)
+	}
+}
+
+func TestSyncChecksum(t *testing.T) {
+	src, dest, cleanup := tempDirs(t)
+	defer cleanup()
+
+	writeFiles(t, src, map[string]string{"a.go": "package a", "b.go": "package b"})
+	writeFiles(t, dest, map[string]string{"a.go": "package a", "b.go": "package c"})
+
+	changes, err := Sync(src, dest, Options{Checksum: true})
+	if err != nil || !reflect.DeepEqual(changes, []Change{{"b.go", Updated}}) {
+		t.Errorf(`Sync => %v, %v; want only "b.go" to be updated`, changes, err)
+	}
+}
+
+func TestSyncEmptyDirs(t *testing.T) {
+	src, dest, cleanup := tempDirs(t)
+	defer cleanup()
+
+	writeFiles(t, src, map[string]string{"a.go": "package a"})
+	if err := os.MkdirAll(filepath.Join(src, ".svn", "tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Sync(src, dest, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, ".svn")); !os.IsNotExist(err) {
+		t.Errorf(`Sync => expected an empty directory not to be created`)
+	}
+	changes, err := Sync(src, dest, Options{EmptyDirs: true})
+	if err != nil || len(changes) != 0 {
+		t.Errorf(`Sync => %v, %v; want no changes`, changes, err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, ".svn", "tmp")); err != nil || !info.IsDir() {
+		t.Errorf(`Sync => expected an empty directory to be created with EmptyDirs`)
+	}
+}
+
+func TestSyncSymlinks(t *testing.T) {
+	src, dest, cleanup := tempDirs(t)
+	defer cleanup()
+
+	writeFiles(t, src, map[string]string{"a.go": "package a"})
+	writeFiles(t, dest, map[string]string{"link": "a regular file"})
+	if err := os.Symlink("a.go", filepath.Join(src, "link")); err != nil {
+		t.Skip(err)
+	}
+
+	changes, err := Sync(src, dest, Options{})
+	if err != nil || !reflect.DeepEqual(changes, []Change{{"a.go", Added}, {"link", Updated}}) {
+		t.Errorf(`Sync => %v, %v; want "link" to be replaced`, changes, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "link")); err != nil || target != "a.go" {
+		t.Errorf(`Sync => link to %q, %v; want a link to "a.go"`, target, err)
+	}
+	if changes, err := Sync(src, dest, Options{}); err != nil || len(changes) != 0 {
+		t.Errorf(`Sync => %v, %v; want no changes`, changes, err)
+	}
+}
@@ -0,0 +1,238 @@
+// Package gomod reads and writes the subset of the "go.mod" file format that
+// is needed to treat the requirements of a Go module as dependencies:
+//
+//	module example.com/a
+//
+//	go 1.16
+//
+//	require (
+//	    example.com/b v1.2.3
+//	    example.com/c v0.0.0-20200101120000-0123456789ab // indirect
+//	)
+//
+//	replace example.com/d => ../d
+//
+// The "exclude", "retract" and "toolchain" directives are ignored.
+package gomod
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type File struct {
+	Module  string
+	Go      string
+	Require []Require
+	Replace []Replace
+}
+
+type Require struct {
+	Path     string
+	Version  string
+	Indirect bool
+}
+
+// Replace substitutes a module (or only one version of the module, if
+// "OldVersion" is set) with another module, or with a directory if
+// "NewVersion" is empty.
+type Replace struct {
+	Old, OldVersion string
+	New, NewVersion string
+}
+
+// Parse reads the contents of a "go.mod" file.
+func Parse(data []byte) (*File, error) {
+	f := new(File)
+	block := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		lineno := i + 1
+		indirect := false
+		if comment := strings.Index(line, "//"); comment >= 0 {
+			indirect = strings.TrimSpace(line[comment+2:]) == "indirect"
+			line = line[:comment]
+		}
+		fields, err := splitFields(line)
+		if err != nil {
+			return nil, fmt.Errorf("go.mod:%d: %s", lineno, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		// Directives in a block have an implicit verb
+		verb, args := fields[0], fields[1:]
+		if len(block) > 0 {
+			if verb == ")" && len(args) == 0 {
+				block = ""
+				continue
+			}
+			verb, args = block, fields
+		} else if len(args) == 1 && args[0] == "(" {
+			block = verb
+			continue
+		}
+
+		switch verb {
+		case "module":
+			if len(args) != 1 {
+				return nil, fmt.Errorf("go.mod:%d: usage: module path", lineno)
+			}
+			f.Module = args[0]
+		case "go":
+			if len(args) != 1 {
+				return nil, fmt.Errorf("go.mod:%d: usage: go 1.23", lineno)
+			}
+			f.Go = args[0]
+		case "require":
+			if len(args) != 2 {
+				return nil, fmt.Errorf("go.mod:%d: usage: require module/path v1.2.3", lineno)
+			}
+			f.Require = append(f.Require, Require{Path: args[0], Version: args[1], Indirect: indirect})
+		case "replace":
+			arrow := indexOf(args, "=>")
+			replace := Replace{}
+			switch {
+			case arrow == 1 && len(args) >= 3:
+				replace.Old = args[0]
+			case arrow == 2 && len(args) >= 4:
+				replace.Old, replace.OldVersion = args[0], args[1]
+			default:
+				return nil, fmt.Errorf("go.mod:%d: usage: replace module/path [v1.2.3] => other/module v1.4 (or a directory)", lineno)
+			}
+			switch rest := args[arrow+1:]; len(rest) {
+			case 1:
+				replace.New = rest[0]
+			case 2:
+				replace.New, replace.NewVersion = rest[0], rest[1]
+			default:
+				return nil, fmt.Errorf("go.mod:%d: usage: replace module/path [v1.2.3] => other/module v1.4 (or a directory)", lineno)
+			}
+			f.Replace = append(f.Replace, replace)
+		case "exclude", "retract", "toolchain", "godebug":
+			// NOTE: These don't affect which dependencies are needed
+		default:
+			return nil, fmt.Errorf("go.mod:%d: unknown directive: %s", lineno, verb)
+		}
+	}
+	if len(block) > 0 {
+		return nil, fmt.Errorf("go.mod: unterminated %s block", block)
+	}
+	if len(f.Module) == 0 {
+		return nil, fmt.Errorf("go.mod: no module declaration")
+	}
+	return f, nil
+}
+
+// splitFields splits a line on whitespace, and unquotes quoted strings.
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	for line = strings.TrimSpace(line); len(line) > 0; line = strings.TrimSpace(line) {
+		if line[0] == '"' || line[0] == '`' {
+			prefix, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string: %s", line)
+			}
+			field, _ := strconv.Unquote(prefix)
+			fields = append(fields, field)
+			line = line[len(prefix):]
+			continue
+		}
+
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+	return fields, nil
+}
+
+func indexOf(fields []string, s string) int {
+	for i, field := range fields {
+		if field == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// Format writes a "go.mod" file in the same layout as "go mod tidy".
+func Format(f *File) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "module %s\n", quote(f.Module))
+	if len(f.Go) > 0 {
+		fmt.Fprintf(&buf, "\ngo %s\n", f.Go)
+	}
+
+	var direct, indirect []Require
+	for _, require := range f.Require {
+		if require.Indirect {
+			indirect = append(indirect, require)
+		} else {
+			direct = append(direct, require)
+		}
+	}
+	for _, requires := range [][]Require{direct, indirect} {
+		if len(requires) == 0 {
+			continue
+		}
+		buf.WriteString("\nrequire (\n")
+		for _, require := range requires {
+			fmt.Fprintf(&buf, "\t%s %s", quote(require.Path), require.Version)
+			if require.Indirect {
+				buf.WriteString(" // indirect")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(")\n")
+	}
+
+	if len(f.Replace) > 0 {
+		buf.WriteString("\n")
+		for _, replace := range f.Replace {
+			buf.WriteString("replace " + quote(replace.Old))
+			if len(replace.OldVersion) > 0 {
+				buf.WriteString(" " + replace.OldVersion)
+			}
+			buf.WriteString(" => " + quote(replace.New))
+			if len(replace.NewVersion) > 0 {
+				buf.WriteString(" " + replace.NewVersion)
+			}
+			buf.WriteString("\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+func quote(s string) string {
+	if len(s) == 0 || strings.ContainsAny(s, " \t\"'`\\") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+var pseudoVersion = regexp.MustCompile(`^v[0-9]+\.(0\.0-|\d+\.\d+-([^+]*\.)?0\.)\d{14}-([A-Za-z0-9]+)(\+[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`)
+
+// PseudoRevision returns the revision identifier (a commit hash prefix) of a
+// pseudo-version such as "v0.0.0-20200101120000-0123456789ab".
+func PseudoRevision(version string) (string, bool) {
+	match := pseudoVersion.FindStringSubmatch(version)
+	if match == nil {
+		return "", false
+	}
+	return match[3], true
+}
+
+// PseudoVersion returns the pseudo-version of an untagged commit.
+func PseudoVersion(commitTime time.Time, revision string) string {
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	return "v0.0.0-" + commitTime.UTC().Format("20060102150405") + "-" + revision
+}
@@ -0,0 +1,122 @@
+package gomod
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const example = `// A comment
+module "example.com/a"
+
+go 1.16
+
+require example.com/b v1.2.3
+require (
+	example.com/c v0.0.0-20200101120000-0123456789ab // indirect
+	example.com/d v2.0.1+incompatible
+)
+
+exclude example.com/b v1.2.2
+
+replace (
+	example.com/d => ../d
+	example.com/e v1.0.0 => example.com/f v1.1.0
+)
+`
+
+func TestParse(t *testing.T) {
+	f, err := Parse([]byte(example))
+	if err != nil {
+		t.Fatalf(`Parse => unexpected error %v`, err)
+	}
+	expect := &File{
+		Module: "example.com/a",
+		Go:     "1.16",
+		Require: []Require{
+			{Path: "example.com/b", Version: "v1.2.3"},
+			{Path: "example.com/c", Version: "v0.0.0-20200101120000-0123456789ab", Indirect: true},
+			{Path: "example.com/d", Version: "v2.0.1+incompatible"},
+		},
+		Replace: []Replace{
+			{Old: "example.com/d", New: "../d"},
+			{Old: "example.com/e", OldVersion: "v1.0.0", New: "example.com/f", NewVersion: "v1.1.0"},
+		},
+	}
+	if !reflect.DeepEqual(f, expect) {
+		t.Errorf(`Parse => %#v; want %#v`, f, expect)
+	}
+
+	for _, input := range []string{
+		"go 1.16\n",
+		"module a\nrequire b\n",
+		"module a\nrequire (\nb v1.0.0\n",
+		"module a\nreplace b v1.0.0\n",
+		"module a\nunknown b\n",
+	} {
+		if _, err := Parse([]byte(input)); err == nil {
+			t.Errorf(`Parse(%q) => expected an error`, input)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	f, err := Parse([]byte(example))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `module example.com/a
+
+go 1.16
+
+require (
+	example.com/b v1.2.3
+	example.com/d v2.0.1+incompatible
+)
+
+require (
+	example.com/c v0.0.0-20200101120000-0123456789ab // indirect
+)
+
+replace example.com/d => ../d
+replace example.com/e v1.0.0 => example.com/f v1.1.0
+`
+	if actual := string(Format(f)); actual != expect {
+		t.Errorf("Format =>\n%s\nwant:\n%s", actual, expect)
+	}
+
+	again, err := Parse(Format(f))
+	if err != nil {
+		t.Fatalf(`Parse(Format(f)) => unexpected error %v`, err)
+	}
+	if actual := string(Format(again)); actual != expect {
+		t.Errorf("Format(Parse(Format(f))) =>\n%s\nwant:\n%s", actual, expect)
+	}
+}
+
+func TestPseudoVersion(t *testing.T) {
+	for _, v := range []struct {
+		version  string
+		revision string
+	}{
+		{"v0.0.0-20200101120000-0123456789ab", "0123456789ab"},
+		{"v1.2.4-0.20200101120000-0123456789ab", "0123456789ab"},
+		{"v1.2.3-pre.0.20200101120000-0123456789ab", "0123456789ab"},
+		{"v2.0.0-20200101120000-0123456789ab+incompatible", "0123456789ab"},
+	} {
+		revision, ok := PseudoRevision(v.version)
+		if !ok || revision != v.revision {
+			t.Errorf(`PseudoRevision(%q) => %q, %v; want %q`, v.version, revision, ok, v.revision)
+		}
+	}
+	for _, version := range []string{"v1.2.3", "v1.2.3-rc.1", "v2.0.1+incompatible"} {
+		if _, ok := PseudoRevision(version); ok {
+			t.Errorf(`PseudoRevision(%q) => expected not to be a pseudo-version`, version)
+		}
+	}
+
+	commitTime := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	if v := PseudoVersion(commitTime, "0123456789abcdef0123"); v != "v0.0.0-20200101120000-0123456789ab" {
+		t.Errorf(`PseudoVersion => %q`, v)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"bottle/shutil"
+)
+
+// dependencyEdge is an entry in a package's config, which depends on another
+// package in the graph.
+type dependencyEdge struct {
+	Importer string // the import path of the package with the config
+	Config   string // the config file which contains the entry
+	Key      string // the import path of the entry in the config
+	Meta     configDependency
+	Target   string // the canonical import path of the dependency
+}
+
+func (deps *DependencyTracker) addEdge(cfg *Config, importer, key string, meta configDependency, target string) {
+	config := shutil.Path(cfg.Project, "Bottle.toml")
+	if len(cfg.Module) > 0 {
+		config = shutil.Path(cfg.Package.Root, "go.mod")
+	}
+	edge := dependencyEdge{Importer: importer, Config: config, Key: key, Meta: meta, Target: target}
+
+	// NOTE: A re-resolved package adds its config's entries again, so replace
+	// them instead of recording every chain twice
+	for i := range deps.edges {
+		if deps.edges[i].Importer == importer && deps.edges[i].Key == key {
+			deps.edges[i] = edge
+			return
+		}
+	}
+	deps.edges = append(deps.edges, edge)
+}
+
+// GraphNode is a package in the resolved dependency graph.
+type GraphNode struct {
+	ImportPath   string   `json:"import_path"`
+	Protocol     string   `json:"protocol"`
+	Repository   string   `json:"repository"`
+	Revision     string   `json:"revision,omitempty"`
+	Version      string   `json:"version,omitempty"`
+	Install      bool     `json:"install"`
+	Dependencies []string `json:"dependencies"`
+}
+
+// Graph returns the root package and every resolved dependency, sorted by
+// their import paths (after the root package).
+func (deps *DependencyTracker) Graph() []GraphNode {
+	root := deps.rootConfig.Package.Name
+	nodes := []GraphNode{{ImportPath: root, Protocol: "path", Repository: "."}}
+	for dep := range deps.revisions {
+		locked := deps.lockedDependency(dep)
+		nodes = append(nodes, GraphNode{
+			ImportPath: locked.ImportPath,
+			Protocol:   locked.Protocol,
+			Repository: locked.Repository,
+			Revision:   locked.Revision,
+			Version:    locked.Version,
+			Install:    deps.isInstalled(locked.ImportPath),
+		})
+	}
+	sort.Slice(nodes[1:], func(i, j int) bool {
+		return nodes[i+1].ImportPath < nodes[j+1].ImportPath
+	})
+
+	for i := range nodes {
+		nodes[i].Dependencies = deps.dependenciesOf(nodes[i].ImportPath)
+	}
+	return nodes
+}
+
+// dependenciesOf returns the sorted import paths of a package's dependencies.
+func (deps *DependencyTracker) dependenciesOf(importPath string) []string {
+	seen := make(map[string]bool)
+	dependencies := []string{}
+	for _, edge := range deps.edges {
+		if edge.Importer == importPath && !seen[edge.Target] {
+			seen[edge.Target] = true
+			dependencies = append(dependencies, edge.Target)
+		}
+	}
+	sort.Strings(dependencies)
+	return dependencies
+}
+
+// isInstalled returns whether the package (or one of its subpackages) is
+// installed as a tool in the workspace.
+func (deps *DependencyTracker) isInstalled(importPath string) bool {
+	for packagePath := range deps.installPackages {
+		if packagePath == importPath || strings.HasPrefix(packagePath, importPath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatTree draws the graph as a tree, starting from the root package.  The
+// dependencies of a package which appears more than once are only shown the
+// first time.
+func FormatTree(nodes []GraphNode) string {
+	byPath := make(map[string]GraphNode)
+	for _, node := range nodes {
+		byPath[node.ImportPath] = node
+	}
+
+	var lines []string
+	shown := make(map[string]bool)
+	var walk func(node GraphNode, prefix, branch, indent string)
+	walk = func(node GraphNode, prefix, branch, indent string) {
+		line := prefix + branch + node.ImportPath
+		if node.Repository != "." {
+			line += "  " + node.Protocol + " " + node.Repository
+		}
+		if len(node.Revision) > 0 {
+			line += "  " + formatRevision(LockedDependency{Revision: node.Revision, Version: node.Version})
+		}
+		if node.Install {
+			line += "  [install]"
+		}
+		if shown[node.ImportPath] && len(node.Dependencies) > 0 {
+			lines = append(lines, line+"  (*)")
+			return
+		}
+		lines = append(lines, line)
+		shown[node.ImportPath] = true
+
+		for i, dependency := range node.Dependencies {
+			if i == len(node.Dependencies)-1 {
+				walk(byPath[dependency], prefix+indent, "└── ", "    ")
+			} else {
+				walk(byPath[dependency], prefix+indent, "├── ", "│   ")
+			}
+		}
+	}
+	if len(nodes) > 0 {
+		walk(nodes[0], "", "", "")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FormatDot writes the graph in the Graphviz DOT language.
+func FormatDot(nodes []GraphNode) string {
+	lines := []string{"digraph dependencies {", "\tnode [shape=box];"}
+	for _, node := range nodes {
+		label := node.ImportPath
+		if node.Repository != "." {
+			label += "\\n" + node.Protocol + " " + node.Repository
+		}
+		if len(node.Revision) > 0 {
+			label += "\\n" + formatRevision(LockedDependency{Revision: node.Revision, Version: node.Version})
+		}
+		attributes := "label=" + dotQuote(label)
+		if node.Install {
+			attributes += ", style=bold"
+		}
+		lines = append(lines, fmt.Sprintf("\t%s [%s];", dotQuote(node.ImportPath), attributes))
+	}
+	for _, node := range nodes {
+		for _, dependency := range node.Dependencies {
+			lines = append(lines, fmt.Sprintf("\t%s -> %s;", dotQuote(node.ImportPath), dotQuote(dependency)))
+		}
+	}
+	lines = append(lines, "}")
+	return strings.Join(lines, "\n")
+}
+
+func dotQuote(s string) string {
+	// NOTE: Escaped newlines ("\n") in labels are kept as they are
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// Why returns every chain of config entries which leads from the root package
+// to a package (or to the dependency that contains it).
+func (deps *DependencyTracker) Why(importPath string) ([][]dependencyEdge, error) {
+	target := ""
+	for _, edge := range deps.edges {
+		if (importPath == edge.Target || strings.HasPrefix(importPath, edge.Target+"/")) && len(edge.Target) > len(target) {
+			target = edge.Target
+		}
+	}
+	if len(target) == 0 {
+		return nil, fmt.Errorf(`"%s" is not in the dependency graph`, importPath)
+	}
+
+	var chains [][]dependencyEdge
+	var walk func(to string, chain []dependencyEdge, visited map[string]bool)
+	walk = func(to string, chain []dependencyEdge, visited map[string]bool) {
+		if to == deps.rootConfig.Package.Name {
+			chains = append(chains, append([]dependencyEdge(nil), chain...))
+			return
+		}
+		visited[to] = true
+		defer delete(visited, to)
+		for _, edge := range deps.edges {
+			if edge.Target == to && !visited[edge.Importer] {
+				walk(edge.Importer, append([]dependencyEdge{edge}, chain...), visited)
+			}
+		}
+	}
+	walk(target, nil, make(map[string]bool))
+
+	sort.Slice(chains, func(i, j int) bool {
+		if len(chains[i]) != len(chains[j]) {
+			return len(chains[i]) < len(chains[j])
+		}
+		return formatChain(chains[i]) < formatChain(chains[j])
+	})
+	return chains, nil
+}
+
+// formatChain shows the config entries in a chain, one per line.
+func formatChain(chain []dependencyEdge) string {
+	var lines []string
+	for _, edge := range chain {
+		lines = append(lines, edge.Importer+" ("+edge.Config+")", "    "+formatConfigEntry(edge.Key, edge.Meta))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestWhyAfterReresolve(t *testing.T) {
+	deps, a, b := newConflictTracker(t, configDependency{}, configDependency{Version: "^1"}, configDependency{})
+
+	// NOTE: A re-resolved package adds the entries of its config again
+	for _, importer := range []string{"example.com/a", "example.com/a", "example.com/b"} {
+		cfg := a
+		if importer == "example.com/b" {
+			cfg = b
+		}
+		if err := deps.addPackage(cfg, importer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chains, err := deps.Why("example.com/lib/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, chain := range chains {
+		got = append(got, chain[0].Key+" -> "+chain[len(chain)-1].Importer)
+	}
+	if len(got) != 2 || got[0] != "example.com/a -> example.com/a" || got[1] != "example.com/b -> example.com/b" {
+		t.Errorf("Why => %v; want one chain through each importer", got)
+	}
+}
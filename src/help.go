@@ -4,9 +4,20 @@ import "bottle/shutil"
 
 var commandDescriptions = `
 Commands:
+  add        Add a dependency to the current project
   build      Compile the current project
+  cache      Manage the shared dependency cache
+  clean      Remove the project's workspace, or other workspaces
   exec       Execute a tool within the virtual GOPATH
+  graph      Print the project's dependency graph
+  init       Create a Bottle.toml for an existing Go project
+  mod        Describe the current project as a Go module
   publish    Package and release the current project
+  remove     Remove a dependency from the current project
+  run        Build and execute one of the project's executables
+  test       Run the tests of the current project
+  update     Fetch newer revisions of the project's dependencies
+  vendor     Copy the project's dependencies into its vendor directory
   which      Find which project contains the target file`
 
 func printHelp() {
@@ -19,7 +30,63 @@ Usage:
 Options:
   -h, --help
       Print this message (use "bottle help <command>" for more)
-` + commandDescriptions)
+  --offline
+      Resolve dependencies only from the workspace and the shared cache,
+      without accessing the network (or set BOTTLE_OFFLINE=1)
+  --jobs int
+      Resolve at most this many dependencies at the same time (or set
+      BOTTLE_JOBS, or "jobs" in the user config); the default is 8
+  --lock-timeout duration
+      How long to wait for another bottle process to release the project's
+      workspace (or set BOTTLE_LOCK_TIMEOUT); the default is 5m
+  --workspace string
+      Use this directory as the workspace (the GOPATH) of the project (or
+      set BOTTLE_WORKSPACE, or "workspace" in the [package] of Bottle.toml);
+      by default it is a directory in $TMPDIR/bottle named by the package
+      and a hash of the project directory
+` + commandDescriptions + `
+
+Topics:
+  exclude    Keep files from being copied into the workspace
+  patch      Replace the source of a dependency anywhere in the graph
+  resolvers  Fetch dependencies with a custom protocol`)
+}
+
+func printHelpAdd() {
+	shutil.Echo(`Add a dependency to the current project
+
+Usage:
+  bottle add <import-path> [options]
+
+Options:
+  -h, --help
+      Print this message
+  --git string
+      Clone the dependency from this git repository
+  --path string
+      Copy the dependency from this directory
+  --rev string
+      Use this commit of the dependency
+  --tag string
+      Use this tag of the dependency
+  --branch string
+      Use this branch of the dependency
+  --version string
+      Use the highest tag matching this semantic version constraint
+  --install
+      Install the dependency's executables in the workspace
+
+Notes:
+  "add" adds the dependency to the [dependencies] of the project's
+  Bottle.toml, without changing the rest of the file.  If the dependency is
+  already there, the options are set in its entry, and its other keys and
+  comments are kept (except that a source or a revision replaces the
+  existing one, eg. "--tag" replaces a "branch").  Then it resolves the
+  dependencies and updates the workspace; if that fails, Bottle.toml and
+  Bottle.lock are restored.
+
+  Without "--git" or "--path", the source of the dependency is found from
+  its import path.`)
 }
 
 func printHelpBuild() {
@@ -39,6 +106,64 @@ Options:
       Arguments to pass on each "go tool link" invocation`)
 }
 
+func printHelpCache() {
+	shutil.Echo(`Manage the shared dependency cache
+
+Usage:
+  bottle cache list
+  bottle cache verify
+  bottle cache prune [options]
+
+Options:
+  -h, --help
+      Print this message
+  --days int
+      Prune entries which haven't been used for this many days (default 30)
+  --all
+      Prune every entry
+
+Notes:
+  Git dependencies are cloned into each workspace from a bare mirror in the
+  cache, and checkouts of other dependencies are saved as snapshots of each
+  revision, so that projects with the same dependencies share them.
+
+  The cache is "$BOTTLE_CACHE" if it is set, the "cache" directory in the
+  user's config file, or else "$XDG_CACHE_HOME/bottle" (by default in
+  "~/.cache").
+
+  "verify" checks the integrity of each mirror and that no snapshot has been
+  modified.`)
+}
+
+func printHelpClean() {
+	shutil.Echo(`Remove the project's workspace, or other workspaces
+
+Usage:
+  bottle clean [options]
+
+Options:
+  -h, --help
+      Print this message
+  --deps
+      Remove only the dependencies (and the packages and executables built
+      in the workspace), but keep the project
+  --all
+      Remove every workspace in $TMPDIR/bottle, instead of only the
+      project's workspace
+  -n, --dry-run
+      Print what would be removed and its size, without removing anything
+
+Notes:
+  "clean" waits for other bottle processes which are using the project's
+  workspace, but "clean --all" skips the workspaces which are in use.  The
+  shared dependency cache isn't changed (see "bottle cache prune").
+
+  A workspace chosen with "--workspace", BOTTLE_WORKSPACE, or "workspace" in
+  the project's config is only removed if it has the ".bottle-lock" file of
+  a bottle workspace and doesn't contain the project.  Those workspaces
+  aren't removed by "clean --all".`)
+}
+
 func printHelpWhich() {
 	shutil.Echo(`Find which project contains the target file
 
@@ -50,6 +175,8 @@ Options:
       Print this message
   --root
       Print the root package path instead of the project path
+  --workspace
+      Print the project's workspace (its GOPATH) instead of the project path
 
 Example:
   [myproject] /path/to/project`)
@@ -74,6 +201,238 @@ Notes:
   Any added or updated files are synchronized back to the source directory.`)
 }
 
+func printHelpRemove() {
+	shutil.Echo(`Remove a dependency from the current project
+
+Usage:
+  bottle remove <import-path>
+
+Options:
+  -h, --help
+      Print this message
+
+Notes:
+  "remove" removes the dependency from the [dependencies] of the project's
+  Bottle.toml, without changing the rest of the file, and then updates the
+  workspace and Bottle.lock.`)
+}
+
+func printHelpRun() {
+	shutil.Echo(`Build and execute one of the project's executables
+
+Usage:
+  bottle run [options] [-- args...]
+
+Options:
+  -h, --help
+      Print this message
+  --bin string
+      Run the [[bin]] target with this name (required if there are several)
+
+Notes:
+  This builds only the selected [[bin]] target, and then executes it in the
+  current directory with the trailing arguments.  The program reads and
+  writes bottle's standard input and output, receives the signals sent to
+  bottle, and its exit code is bottle's exit code.`)
+}
+
+func printHelpTest() {
+	shutil.Echo(`Run the tests of the current project
+
+Usage:
+  bottle test [options] [package...]
+
+Options:
+  -h, --help
+      Print this message
+  -run string
+      Run only the tests matching this regular expression
+  -race
+      Enable the race detector
+  -cover
+      Report the test coverage of each package
+  -v
+      Print the output of every test
+  --deps
+      Also test each of the project's "path" dependencies
+
+Notes:
+  This runs "go test" in the project's root package in the workspace.  The
+  packages are directories relative to the current directory (which must be
+  in the root package, eg. "./sub/...") or import paths.  By default, every
+  package in the project is tested.  The options can also be given after
+  the packages (eg. "bottle test ./... -run TestX").
+
+  Paths in the workspace are replaced by their source directories in the
+  output of the tests.`)
+}
+
+func printHelpUpdate() {
+	shutil.Echo(`Fetch newer revisions of the project's dependencies
+
+Usage:
+  bottle update [import-path...]
+
+Options:
+  -h, --help
+      Print this message
+
+Notes:
+  By default, dependencies are checked out at the revisions pinned in
+  Bottle.lock.  This ignores the pinned revisions of the named dependencies
+  (or all dependencies, if none are named) and fetches the latest revision
+  allowed by each dependency's "rev", "tag", "branch" or "version".
+
+  The lockfile is updated, and each dependency that changed is printed with
+  its old and new revisions.`)
+}
+
+func printHelpVendor() {
+	shutil.Echo(`Copy the project's dependencies into its vendor directory
+
+Usage:
+  bottle vendor
+
+Options:
+  -h, --help
+      Print this message
+
+Notes:
+  This resolves the project's dependencies, and then replaces the "vendor"
+  directory in the project's root package with every package that is
+  imported by the project or its tests.  Other packages in the dependencies
+  are left out, except for their license files.
+
+  The origin and revision of each dependency is recorded in
+  "vendor/bottle-vendor.toml".  With a vendor directory, the project can be
+  built by the go tool without Bottle (in a GOPATH, or as a module after
+  "bottle mod export").
+
+  The dependencies are copied with their imports of renamed ("as")
+  dependencies rewritten.  A project which renames dependencies in its own
+  Bottle.toml can't be vendored, because its source isn't rewritten.`)
+}
+
+func printHelpGraph() {
+	shutil.Echo(`Print the project's dependency graph
+
+Usage:
+  bottle graph [options]
+
+Options:
+  -h, --help
+      Print this message
+  --dot
+      Print the graph in the Graphviz DOT language
+  --json
+      Print the graph as a JSON array of packages
+  --why string
+      Print each chain of config entries which requires a package
+
+Notes:
+  This resolves the project's dependencies, and prints a tree of the
+  packages which depend on each other.  Each dependency is shown with its
+  protocol, repository and revision, and "[install]" if it is installed as a
+  tool.  A package which appears more than once only shows its dependencies
+  the first time, and is marked with "(*)" afterwards.`)
+}
+
+func printHelpMod() {
+	shutil.Echo(`Describe the current project as a Go module
+
+Usage:
+  bottle mod export [options]
+
+Options:
+  -h, --help
+      Print this message
+  --force
+      Overwrite the project's existing go.mod
+
+Notes:
+  "export" resolves the project's dependencies, and writes a go.mod to the
+  project's root package which requires the revision of each dependency
+  pinned in Bottle.lock.  A dependency selected by a version (or a tag which
+  is a version) requires that version, and any other git dependency requires
+  a pseudo-version of its commit.  Each "path" dependency is replaced by its
+  directory.  Run "go mod tidy" afterwards to create go.sum.  An existing
+  go.mod is only overwritten with "--force".
+
+  Dependencies without a Bottle.toml but with a go.mod use the module's
+  requirements as their dependencies.  Each required version selects the
+  highest tag with the same major version, and pseudo-versions select their
+  commit.`)
+}
+
+func printHelpExclude() {
+	shutil.Echo(`Keep files from being copied into the workspace
+
+Usage:
+  [package]
+  exclude = ["testdata", "/assets/gen/", "*.min.js", "!keep.min.js"]
+  gitignore = true
+
+Notes:
+  The project's root package and each "path" dependency are copied into the
+  workspace before building, except for hidden files and the files matched
+  by the "exclude" patterns in the [package] of their Bottle.toml.  With
+  "gitignore", the files ignored by the package's ".gitignore" files are
+  excluded too.
+
+  The patterns have the same syntax as ".gitignore", relative to the
+  package's root directory.  A pattern without a "/" matches a name in any
+  directory, a "**" matches any number of directories, a trailing "/" only
+  matches directories, and a leading "!" includes the files matched by an
+  earlier pattern.  The "exclude" patterns take precedence over
+  ".gitignore".
+
+  Excluded files which were already copied into the workspace are kept.`)
+}
+
+func printHelpInit() {
+	shutil.Echo(`Create a Bottle.toml for an existing Go project
+
+Usage:
+  bottle init
+
+Options:
+  -h, --help
+      Print this message
+
+Notes:
+  "init" writes a Bottle.toml in the current directory, which must not
+  already have one.  The package name is the module path in go.mod, or the
+  path of the git remote "origin", or else the name of the directory.  Each
+  "main" package is added as a [[bin]] target.
+
+  The imports of the project's packages (except the directories ignored by
+  the go tool) are added as dependencies.  An import of a project in a
+  sibling directory is a "path" dependency, and other imports have no
+  source, so imports from GitHub or Bitbucket are cloned with git and other
+  imports are fetched with "go get".  Check the dependencies of other
+  imports, because their import paths are guessed from the first three
+  elements of each import.`)
+}
+
+func printHelpPatch() {
+	shutil.Echo(`Replace the source of a dependency anywhere in the graph
+
+Usage:
+  [patch]
+  "github.com/a/lib" = { path = "../lib" }
+  "example.com/b" = { git = "https://github.com/me/b.git", branch = "fix" }
+
+Notes:
+  Each entry in the project's [patch] table replaces the source of the
+  dependency with that import path (or one of its subpackages), whether it
+  is required by the project or by any of its dependencies.  The entries
+  have the same source and revision keys as [dependencies], but no
+  "install" or "as" keys.  A "path" is relative to the project directory.
+
+  Patches in the Bottle.toml of a dependency are ignored.  A warning is
+  printed for each patch which doesn't match any dependency.`)
+}
+
 func printHelpPublish() {
 	shutil.Echo(`Package and release the current project
 
@@ -91,3 +450,45 @@ Warning:
   If you'd like to share some ideas about how to implement more-generic
   publishing, write up a detailed description in a GitHub issue.`)
 }
+
+func printHelpResolvers() {
+	shutil.Echo(`Fetch dependencies with a custom protocol
+
+Usage:
+  [dependencies]
+  "corp.example/lib" = { protocol = "artifact", source = "lib-1.4.2.tgz" }
+
+Notes:
+  A dependency with an unknown protocol is fetched by an external resolver.
+  The resolver is the executable configured for the protocol in the user's
+  config file (see below), or else the executable named
+  "bottle-resolver-<protocol>" in the PATH.
+
+  The resolver is run in the workspace directory with three arguments:
+
+      bottle-resolver-<protocol> <source> <import-path> <workspace>
+
+  It must populate "<workspace>/src/<import-path>" with the package.  The
+  environment contains BOTTLE_PROTOCOL and BOTTLE_DEST (the package
+  directory), and the BOTTLE_REV, BOTTLE_TAG and BOTTLE_BRANCH selected by the
+  dependency or pinned in Bottle.lock.  BOTTLE_REFRESH is set to "1" when the
+  resolver should fetch the latest revision, even if the package exists.
+  BOTTLE_OFFLINE is set to "1" when the resolver must not access the
+  network.
+
+  The resolver exits with status 0 after fetching or updating the package,
+  or with status 100 if the package was already resolved and is unchanged.
+  Any other status is an error, and the resolver's stderr is reported.  The
+  last line of stdout is the exact revision which is recorded in Bottle.lock;
+  if it is empty, a hash of the package's contents is used instead.
+
+  If the resolver fails (or is killed because bottle was interrupted), the
+  package directory is removed if the resolver created it.
+
+User config:
+  The user config file is "$BOTTLE_CONFIG" if it is set, or else
+  "$XDG_CONFIG_HOME/bottle/config.toml" (by default in "~/.config").
+
+  [resolvers]
+  artifact = "/usr/local/libexec/fetch-artifact"`)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bottle/gomod"
+	"bottle/shutil"
+	"bottle/toml"
+)
+
+type initBin struct {
+	Name string
+	Path string
+}
+
+// scanPackages returns the imports of the Go packages in a directory tree and
+// the files which contain a "main" package, by directory (relative to "root",
+// with forward slashes).  The directories which are ignored by the go tool
+// (hidden, "_", "testdata" and "vendor") aren't scanned.
+func scanPackages(root string) (imports map[string]bool, mains map[string][]string, err error) {
+	imports = make(map[string]bool)
+	mains = make(map[string][]string)
+	fset := token.NewFileSet()
+	err = filepath.Walk(root, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if filename != root && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(name, ".go") || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			return nil
+		}
+
+		file, err := parser.ParseFile(fset, filename, nil, parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+		for _, spec := range file.Imports {
+			imports[strings.Trim(spec.Path.Value, "\"`")] = true
+		}
+		if file.Name.Name == "main" && !strings.HasSuffix(name, "_test.go") {
+			dir := filepath.ToSlash(shutil.Relpath(root, filepath.Dir(filename)))
+			mains[dir] = append(mains[dir], name)
+		}
+		return nil
+	})
+	return imports, mains, err
+}
+
+// detectImportPath guesses the import path of a project from its go.mod, or
+// from the remote of its git repository, or else from its directory's name.
+func detectImportPath(dir string) string {
+	modpath := shutil.Path(dir, "go.mod")
+	if shutil.IsRegularFile(modpath) {
+		if mod, err := gomod.Parse(shutil.Binread(modpath)); err == nil && len(mod.Module) > 0 {
+			return mod.Module
+		}
+	}
+	if shutil.Exists(shutil.Path(dir, ".git")) {
+		if remote, err := git(context.Background(), dir, `config`, `--get`, `remote.origin.url`); err == nil && len(remote) > 0 {
+			if importPath := remoteImportPath(remote); len(importPath) > 0 {
+				return importPath
+			}
+		}
+	}
+	return filepath.Base(dir)
+}
+
+// remoteImportPath converts a git remote (eg. "https://github.com/a/b.git" or
+// "git@github.com:a/b.git") to an import path, or returns "" if the remote is
+// a local path.
+func remoteImportPath(remote string) string {
+	remote = strings.TrimSuffix(strings.TrimSuffix(remote, "/"), ".git")
+
+	var host, repo string
+	if i := strings.Index(remote, "://"); i >= 0 {
+		parts := strings.SplitN(remote[i+3:], "/", 2)
+		if len(parts) < 2 {
+			return ""
+		}
+		host, repo = parts[0], parts[1]
+		if j := strings.LastIndex(host, ":"); j >= 0 {
+			host = host[:j] // NOTE: drop the port
+		}
+	} else if i := strings.Index(remote, ":"); i > 0 && !strings.Contains(remote[:i], "/") {
+		host, repo = remote[:i], remote[i+1:] // NOTE: scp-like syntax
+	} else {
+		return ""
+	}
+	if j := strings.LastIndex(host, "@"); j >= 0 {
+		host = host[j+1:]
+	}
+	if len(host) == 0 || len(repo) == 0 {
+		return ""
+	}
+	return host + "/" + strings.TrimPrefix(repo, "/")
+}
+
+// siblingProjects returns the import path of each project in the parent of
+// a directory (except the directory itself), by directory.
+func siblingProjects(dir string) map[string]string {
+	siblings := make(map[string]string)
+	parent := filepath.Dir(dir)
+	entries, _ := ioutil.ReadDir(parent)
+	for _, info := range entries {
+		sibling := shutil.Path(parent, info.Name())
+		if !info.IsDir() || sibling == dir || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+
+		cfgpath := shutil.Path(sibling, "Bottle.toml")
+		if shutil.IsRegularFile(cfgpath) {
+			cfg := new(Config)
+			if err := toml.Unmarshal(shutil.Binread(cfgpath), cfg); err == nil && len(cfg.Package.Name) > 0 {
+				siblings[sibling] = cfg.Package.Name
+			}
+		} else if shutil.IsRegularFile(shutil.Path(sibling, "go.mod")) || shutil.Exists(shutil.Path(sibling, ".git")) {
+			siblings[sibling] = detectImportPath(sibling)
+		}
+	}
+	return siblings
+}
+
+// initDependencies classifies the imports of a project: imports of a sibling
+// project are "path" dependencies, and other imports have no source, so they
+// are cloned from GitHub or Bitbucket or fetched with "go get" (see
+// parseDependency).
+func initDependencies(dir, name string, imports map[string]bool) map[string]configDependency {
+	siblings := siblingProjects(dir)
+	dependencies := make(map[string]configDependency)
+	for importPath := range imports {
+		// Skip the standard library and the project's own packages
+		if importPath == "C" || !strings.Contains(strings.Split(importPath, "/")[0], ".") {
+			continue
+		}
+		if importPath == name || strings.HasPrefix(importPath, name+"/") {
+			continue
+		}
+
+		found := false
+		for sibling, prefix := range siblings {
+			if importPath == prefix || strings.HasPrefix(importPath, prefix+"/") {
+				rel := filepath.ToSlash(shutil.Relpath(dir, sibling))
+				dependencies[prefix] = configDependency{Path: rel}
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		dependencies[parseImportPrefix(importPath)] = configDependency{}
+	}
+
+	// NOTE: A "go-get" prefix can be a subpackage of another dependency
+	for importPath := range dependencies {
+		for other := range dependencies {
+			if other != importPath && strings.HasPrefix(importPath, other+"/") {
+				delete(dependencies, importPath)
+				break
+			}
+		}
+	}
+	return dependencies
+}
+
+// initBins returns a [[bin]] entry for each "main" package.
+func initBins(name string, mains map[string][]string) []initBin {
+	var bins []initBin
+	for dir, files := range mains {
+		sort.Strings(files)
+		file := files[0]
+		for _, other := range files {
+			if other == "main.go" {
+				file = other
+			}
+		}
+
+		bin := initBin{Name: path.Base(dir), Path: path.Join(dir, file)}
+		if dir == "." {
+			bin.Name = path.Base(name)
+		}
+		bins = append(bins, bin)
+	}
+	sort.Slice(bins, func(i, j int) bool { return bins[i].Path < bins[j].Path })
+	return bins
+}
+
+// InitConfig creates the config of an existing Go project.
+func InitConfig(dir string) ([]byte, error) {
+	name := detectImportPath(dir)
+	imports, mains, err := scanPackages(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the project's packages:\n\n\t%s\n", err)
+	}
+	return formatInitConfig(name, initBins(name, mains), initDependencies(dir, name, imports)), nil
+}
+
+// formatInitConfig writes a config in the same style as the examples, with
+// each dependency on one line of the [dependencies] table.
+func formatInitConfig(name string, bins []initBin, dependencies map[string]configDependency) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "[package]\nname = %s\n", strconv.Quote(name))
+
+	if len(dependencies) > 0 {
+		var importPaths []string
+		for importPath := range dependencies {
+			importPaths = append(importPaths, importPath)
+		}
+		sort.Strings(importPaths)
+
+		buf.WriteString("\n[dependencies]\n")
+		for _, importPath := range importPaths {
+			buf.WriteString(formatDependency(importPath, dependencyFields(dependencies[importPath])) + "\n")
+		}
+	}
+
+	for _, bin := range bins {
+		fmt.Fprintf(&buf, "\n[[bin]]\nname = %s\npath = %s\n", strconv.Quote(bin.Name), strconv.Quote(bin.Path))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"bottle/shutil"
+)
+
+func TestRemoteImportPath(t *testing.T) {
+	tests := []struct {
+		remote string
+		want   string
+	}{
+		{"https://github.com/a/b.git", "github.com/a/b"},
+		{"https://github.com/a/b/", "github.com/a/b"},
+		{"ssh://git@example.com:2222/team/repo.git", "example.com/team/repo"},
+		{"git@github.com:a/b.git", "github.com/a/b"},
+		{"example.com:repo", "example.com/repo"},
+		{"/home/me/src/b.git", ""},
+		{"../b", ""},
+		{"https://example.com", ""},
+	}
+	for _, test := range tests {
+		if got := remoteImportPath(test.remote); got != test.want {
+			t.Errorf(`remoteImportPath("%s") => "%s"; want "%s"`, test.remote, got, test.want)
+		}
+	}
+}
+
+func TestInitDependencies(t *testing.T) {
+	parent, err := ioutil.TempDir("", "bottle-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+
+	// NOTE: Siblings are found by their Bottle.toml, or else by their go.mod
+	dir := shutil.Path(parent, "app")
+	for filename, data := range map[string]string{
+		"app/main.go":     "package main\n",
+		"lib/Bottle.toml": "[package]\nname = \"example.com/lib\"\n",
+		"mod/go.mod":      "module example.com/mod\n",
+		"notes/todo.txt":  "",
+	} {
+		filename = shutil.Path(parent, filename)
+		shutil.MkdirParents(shutil.Dirname(filename), 0755)
+		if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	imports := map[string]bool{
+		"fmt":                        true,
+		"C":                          true,
+		"example.com/app/internal":   true,
+		"example.com/lib/sub":        true,
+		"example.com/mod":            true,
+		"github.com/a/b/c":           true,
+		"bitbucket.org/c/d":          true,
+		"golang.org/x/tools":         true,
+		"golang.org/x/tools/go/ast":  true,
+		"gopkg.in/yaml.v2":           true,
+		"example.com/other/pkg/deep": true,
+	}
+	want := map[string]configDependency{
+		"example.com/lib":       {Path: "../lib"},
+		"example.com/mod":       {Path: "../mod"},
+		"github.com/a/b":        {},
+		"bitbucket.org/c/d":     {},
+		"golang.org/x/tools":    {},
+		"gopkg.in/yaml.v2":      {},
+		"example.com/other/pkg": {},
+	}
+	if got := initDependencies(dir, "example.com/app", imports); !reflect.DeepEqual(got, want) {
+		t.Errorf("initDependencies =>\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestInitBins(t *testing.T) {
+	mains := map[string][]string{
+		".":     {"main.go"},
+		"cmd/a": {"run.go", "main.go"},
+		"cmd/b": {"b_unix.go", "b.go"},
+	}
+	want := []initBin{
+		{Name: "a", Path: "cmd/a/main.go"},
+		{Name: "b", Path: "cmd/b/b.go"},
+		{Name: "app", Path: "main.go"},
+	}
+	if got := initBins("example.com/app", mains); !reflect.DeepEqual(got, want) {
+		t.Errorf("initBins => %v; want %v", got, want)
+	}
+}
+
+func TestFormatInitConfig(t *testing.T) {
+	bins := []initBin{{Name: "a", Path: "cmd/a/main.go"}, {Name: "b", Path: "cmd/b/main.go"}}
+	dependencies := map[string]configDependency{
+		"github.com/a/b":  {},
+		"example.com/lib": {Path: "../lib"},
+	}
+	want := `[package]
+name = "example.com/app"
+
+[dependencies]
+"example.com/lib" = { path = "../lib" }
+"github.com/a/b" = {}
+
+[[bin]]
+name = "a"
+path = "cmd/a/main.go"
+
+[[bin]]
+name = "b"
+path = "cmd/b/main.go"
+`
+	if got := string(formatInitConfig("example.com/app", bins, dependencies)); got != want {
+		t.Errorf("formatInitConfig =>\n%s\nwant:\n%s", got, want)
+	}
+
+	want = "[package]\nname = \"app\"\n"
+	if got := string(formatInitConfig("app", nil, nil)); got != want {
+		t.Errorf("formatInitConfig =>\n%s\nwant:\n%s", got, want)
+	}
+}
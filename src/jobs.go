@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strconv"
+
+	"bottle/shutil"
+)
+
+// jobs is the maximum number of dependencies which are resolved at the same
+// time.  It is set by the "--jobs" option, or else by setting BOTTLE_JOBS in
+// the environment or "jobs" in the user's config.
+var jobs int
+
+const defaultJobs = 8
+
+// resolveJobs returns the concurrency limit, after the user's config has been
+// read.
+func resolveJobs() int {
+	if jobs > 0 {
+		return jobs
+	}
+	if value, err := strconv.Atoi(shutil.Env()["BOTTLE_JOBS"]); err == nil && value > 0 {
+		return value
+	}
+	if userConfig.Jobs > 0 {
+		return userConfig.Jobs
+	}
+	return defaultJobs
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"bottle/shutil"
+	"bottle/toml"
+)
+
+const lockfileHeader = "# This file is generated by bottle; it is not intended for manual editing.\n\n"
+
+// Lockfile is the format of "Bottle.lock", which pins each resolved dependency
+// to the exact revision that was used to build the project.
+type Lockfile struct {
+	Dependency []LockedDependency
+}
+
+type LockedDependency struct {
+	ImportPath string
+	Protocol   string
+	Repository string // relative to the project directory for "path" dependencies
+	Revision   string // a commit hash, or a content hash prefixed with "sha256:"
+	Requested  string `toml:",omitempty"` // the revision selected by the config when it was resolved
+	Version    string `toml:",omitempty"` // the tag selected to satisfy version constraints
+	Original   string `toml:",omitempty"` // the import path in the config, if it was renamed with "as"
+}
+
+// ReadLockfile loads the revisions pinned by a lockfile, if it exists, so that
+// they are used by the resolvers instead of the latest available revision.
+func (deps *DependencyTracker) ReadLockfile(filename string) error {
+	if !shutil.Exists(filename) {
+		return nil
+	}
+
+	var lock Lockfile
+	err := toml.Unmarshal(shutil.Binread(filename), &lock)
+	if err != nil {
+		return fmt.Errorf("Failed to read lockfile \"%s\":\n\n\t%s\n", filename, err)
+	}
+
+	for _, locked := range lock.Dependency {
+		dep := Dependency{Protocol: locked.Protocol, Repository: locked.Repository}
+		if len(locked.Original) > 0 {
+			dep.Alias = locked.ImportPath
+		}
+		if dep.Protocol == "path" {
+			dep.Repository = shutil.Abspath(shutil.Path(deps.rootConfig.Project, dep.Repository))
+		}
+		deps.pins[dep] = locked
+	}
+	return nil
+}
+
+// WriteLockfile records the revision of every resolved dependency.  The file
+// is only rewritten if one of the revisions has changed.
+func (deps *DependencyTracker) WriteLockfile(filename string) error {
+	var lock Lockfile
+	for dep := range deps.revisions {
+		lock.Dependency = append(lock.Dependency, deps.lockedDependency(dep))
+	}
+	sort.Slice(lock.Dependency, func(i, j int) bool {
+		return lock.Dependency[i].ImportPath < lock.Dependency[j].ImportPath
+	})
+
+	data, err := toml.Marshal(lock)
+	if err != nil {
+		return fmt.Errorf("Failed to encode lockfile:\n\n\t%s\n", err)
+	}
+	data = append([]byte(lockfileHeader), data...)
+	if shutil.IsRegularFile(filename) && bytes.Equal(data, shutil.Binread(filename)) {
+		return nil
+	}
+
+	err = ioutil.WriteFile(filename, data, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to write lockfile \"%s\":\n\n\t%s\n", filename, err)
+	}
+	return nil
+}
+
+// lockedDependency describes the revision of a resolved dependency.
+func (deps *DependencyTracker) lockedDependency(dep Dependency) LockedDependency {
+	locked := LockedDependency{
+		ImportPath: deps.canonicalPaths[dep],
+		Protocol:   dep.Protocol,
+		Repository: dep.Repository,
+		Revision:   deps.revisions[dep],
+		Requested:  deps.requested[dep].String(),
+		Version:    deps.versions[dep],
+		Original:   deps.renamedFrom[dep],
+	}
+	if dep.Protocol == "path" {
+		locked.Repository = filepath.ToSlash(shutil.Relpath(deps.rootConfig.Project, dep.Repository))
+	}
+	return locked
+}
+
+// hashTree computes a content hash of all the files in a directory, ignoring
+// hidden files in the same way that packages are copied into the workspace.
+func hashTree(root string) (string, error) {
+	hash := sha256.New()
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		fmt.Fprintf(hash, "%s\x00%d\x00", filepath.ToSlash(shutil.Relpath(root, path)), info.Size())
+		_, err = io.Copy(hash, file)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("resolver: error hashing \"%s\": %s", root, err)
+	}
+
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type dependencyChange struct {
+	Old, New LockedDependency // "Old" is empty if the dependency was added
+}
+
+// Changes compares the resolved dependencies with the lockfile, and returns
+// the dependencies which were added or checked out at a different revision.
+func (deps *DependencyTracker) Changes() []dependencyChange {
+	var changes []dependencyChange
+	for dep, revision := range deps.revisions {
+		current := deps.lockedDependency(dep)
+		if pin, ok := deps.pins[dep]; !ok || pin.Revision != revision {
+			changes = append(changes, dependencyChange{Old: deps.pins[dep], New: current})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].New.ImportPath < changes[j].New.ImportPath
+	})
+	return changes
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"bottle/debug"
@@ -25,7 +30,20 @@ func main() {
 
 	// Configure cli flags
 	flag.Usage = printHelp
+	flag.BoolVar(&offline, "offline", offlineFromEnv(), "")
+	flag.IntVar(&jobs, "jobs", 0, "")
+	flag.DurationVar(&lockTimeout, "lock-timeout", lockTimeoutFromEnv(), "")
+	flag.StringVar(&workspaceOverride, "workspace", workspaceFromEnv(), "")
 	flag.Parse()
+	if len(workspaceOverride) > 0 {
+		workspaceOverride = shutil.Abspath(workspaceOverride) // NOTE: before changing directories
+	}
+
+	// Read the user's config file
+	err := loadUserConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Parse the command
 	var command string
@@ -40,6 +58,44 @@ func main() {
 
 	// Handle the chosen command
 	switch command {
+	case "add":
+		var meta configDependency
+		add := flag.NewFlagSet("add", flag.ExitOnError)
+		add.Usage = printHelpAdd
+		add.StringVar(&meta.Git, "git", "", "")
+		add.StringVar(&meta.Path, "path", "", "")
+		add.StringVar(&meta.Rev, "rev", "", "")
+		add.StringVar(&meta.Tag, "tag", "", "")
+		add.StringVar(&meta.Branch, "branch", "", "")
+		add.StringVar(&meta.Version, "version", "", "")
+		add.BoolVar(&meta.Install, "install", false, "")
+		add.Parse(args)
+		if add.NArg() > 0 {
+			importPath := add.Arg(0)
+			add.Parse(add.Args()[1:]) // NOTE: allow options after the import path
+			args = append([]string{importPath}, add.Args()...)
+		} else {
+			args = nil
+		}
+		if len(args) != 1 {
+			shutil.Stderr("error: 'add' expects a single import path\n")
+			shutil.Exit(1)
+		}
+
+		if len(meta.Path) > 0 {
+			meta.Path = shutil.Abspath(meta.Path) // NOTE: before changing directories
+		}
+		project := loadProject()
+		if project.Missing {
+			shutil.Stderr("error: could not find Bottle.toml\n")
+			shutil.Exit(1)
+		}
+		if len(meta.Path) > 0 {
+			meta.Path = filepath.ToSlash(shutil.Relpath(project.Project, meta.Path))
+		}
+		addToProject(project, args[0], meta)
+		shutil.Exit(0)
+
 	case "build":
 		var flags BuildFlags
 		build := flag.NewFlagSet("build", flag.ExitOnError)
@@ -58,6 +114,52 @@ func main() {
 		buildProject(project, workdir, flags)
 		shutil.Exit(0)
 
+	case "cache":
+		var flags CacheFlags
+		cache := flag.NewFlagSet("cache", flag.ExitOnError)
+		cache.Usage = printHelpCache
+		cache.IntVar(&flags.days, "days", 30, "")
+		cache.BoolVar(&flags.all, "all", false, "")
+		var action string
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			action, args = args[0], args[1:]
+		}
+		cache.Parse(args)
+		if len(action) == 0 {
+			shutil.Stderr("error: missing cache action (expected list, verify or prune)\n")
+			shutil.Exit(1)
+		} else if len(cache.Args()) > 0 {
+			shutil.Stderr("error: unexpected argument '" + cache.Arg(0) + "'\n")
+			shutil.Exit(1)
+		}
+
+		manageCache(action, flags)
+		shutil.Exit(0)
+
+	case "clean":
+		var flags CleanFlags
+		clean := flag.NewFlagSet("clean", flag.ExitOnError)
+		clean.Usage = printHelpClean
+		clean.BoolVar(&flags.deps, "deps", false, "")
+		clean.BoolVar(&flags.all, "all", false, "")
+		clean.BoolVar(&flags.dryRun, "n", false, "")
+		clean.BoolVar(&flags.dryRun, "dry-run", false, "")
+		clean.Parse(args)
+		if len(clean.Args()) > 0 {
+			shutil.Stderr("error: unexpected argument '" + clean.Arg(0) + "'\n")
+			shutil.Exit(1)
+		} else if flags.deps && flags.all {
+			shutil.Stderr("error: only one of '--deps' or '--all' can be used\n")
+			shutil.Exit(1)
+		}
+
+		if flags.all {
+			cleanAllWorkspaces(flags)
+		} else {
+			cleanProject(loadProject(), flags)
+		}
+		shutil.Exit(0)
+
 	case "exec":
 		exec := flag.NewFlagSet("exec", flag.ExitOnError)
 		exec.Usage = printHelpExec
@@ -73,6 +175,67 @@ func main() {
 		execTool(project, args[0], args[1:])
 		shutil.Exit(0)
 
+	case "graph":
+		var flags GraphFlags
+		graph := flag.NewFlagSet("graph", flag.ExitOnError)
+		graph.Usage = printHelpGraph
+		graph.BoolVar(&flags.dot, "dot", false, "")
+		graph.BoolVar(&flags.json, "json", false, "")
+		graph.StringVar(&flags.why, "why", "", "")
+		graph.Parse(args)
+		if len(graph.Args()) > 0 {
+			shutil.Stderr("error: unexpected argument '" + graph.Arg(0) + "'\n")
+			shutil.Exit(1)
+		} else if flags.dot && flags.json {
+			shutil.Stderr("error: only one of '--dot' or '--json' can be used\n")
+			shutil.Exit(1)
+		}
+
+		project := loadProject()
+		deps := loadDependencies(project)
+		syncWorkspace(project, deps)
+		graphProject(deps, flags)
+		shutil.Exit(0)
+
+	case "init":
+		init := flag.NewFlagSet("init", flag.ExitOnError)
+		init.Usage = printHelpInit
+		init.Parse(args)
+		if len(init.Args()) > 0 {
+			shutil.Stderr("error: unexpected argument '" + init.Arg(0) + "'\n")
+			shutil.Exit(1)
+		}
+
+		initProject(shutil.Pwd())
+		shutil.Exit(0)
+
+	case "mod":
+		var flags ModFlags
+		mod := flag.NewFlagSet("mod", flag.ExitOnError)
+		mod.Usage = printHelpMod
+		mod.BoolVar(&flags.force, "force", false, "")
+		mod.Parse(args)
+		args := mod.Args()
+		if len(args) == 0 {
+			shutil.Stderr("error: missing mod action (expected export)\n")
+			shutil.Exit(1)
+		} else if args[0] != "export" {
+			shutil.Stderr("error: unknown mod action '" + args[0] + "' (expected export)\n")
+			shutil.Exit(1)
+		}
+		mod.Parse(args[1:]) // NOTE: allow options after the action
+		if mod.NArg() > 0 {
+			shutil.Stderr("error: unexpected argument '" + mod.Arg(0) + "'\n")
+			shutil.Exit(1)
+		}
+
+		project := loadProject()
+		checkGoModExport(project, flags)
+		deps := loadDependencies(project)
+		syncWorkspace(project, deps)
+		exportGoMod(project, deps)
+		shutil.Exit(0)
+
 	case "publish":
 		var flags PublishFlags
 		publish := flag.NewFlagSet("publish", flag.ExitOnError)
@@ -89,16 +252,102 @@ func main() {
 		publishProject(project, flags)
 		shutil.Exit(0)
 
+	case "remove":
+		remove := flag.NewFlagSet("remove", flag.ExitOnError)
+		remove.Usage = printHelpRemove
+		remove.Parse(args)
+		if remove.NArg() != 1 {
+			shutil.Stderr("error: 'remove' expects a single import path\n")
+			shutil.Exit(1)
+		}
+
+		project := loadProject()
+		if project.Missing {
+			shutil.Stderr("error: could not find Bottle.toml\n")
+			shutil.Exit(1)
+		}
+		removeFromProject(project, remove.Arg(0))
+		shutil.Exit(0)
+
+	case "run":
+		var flags RunFlags
+		run := flag.NewFlagSet("run", flag.ExitOnError)
+		run.Usage = printHelpRun
+		run.StringVar(&flags.bin, "bin", "", "")
+		run.Parse(args)
+
+		workdir := shutil.Pwd() // NOTE: changed by syncProject
+		project := syncProject(workdir)
+		runBin(project, workdir, run.Args(), flags)
+		shutil.Exit(0)
+
+	case "test":
+		var flags TestFlags
+		test := flag.NewFlagSet("test", flag.ExitOnError)
+		test.Usage = printHelpTest
+		test.StringVar(&flags.run, "run", "", "")
+		test.BoolVar(&flags.race, "race", false, "")
+		test.BoolVar(&flags.cover, "cover", false, "")
+		test.BoolVar(&flags.verbose, "v", false, "")
+		test.BoolVar(&flags.deps, "deps", false, "")
+		test.Parse(args)
+
+		// NOTE: allow options after the packages (eg. "./... -run TestX")
+		var packages []string
+		for test.NArg() > 0 {
+			packages = append(packages, test.Arg(0))
+			test.Parse(test.Args()[1:])
+		}
+
+		workdir := shutil.Pwd() // NOTE: changed by loadProject
+		project := loadProject()
+		deps := loadDependencies(project)
+		syncWorkspace(project, deps)
+		testProject(project, deps, workdir, packages, flags)
+		shutil.Exit(0)
+
+	case "update":
+		update := flag.NewFlagSet("update", flag.ExitOnError)
+		update.Usage = printHelpUpdate
+		update.Parse(args)
+
+		project := loadProject()
+		checkUpdatePaths(project, update.Args())
+		deps := loadDependencies(project)
+		deps.Update(update.Args())
+		syncWorkspace(project, deps)
+		updateProject(deps, update.Args())
+		shutil.Exit(0)
+
+	case "vendor":
+		vendor := flag.NewFlagSet("vendor", flag.ExitOnError)
+		vendor.Usage = printHelpVendor
+		vendor.Parse(args)
+		if len(vendor.Args()) > 0 {
+			shutil.Stderr("error: unexpected argument '" + vendor.Arg(0) + "'\n")
+			shutil.Exit(1)
+		}
+
+		project := loadProject()
+		deps := loadDependencies(project)
+		syncWorkspace(project, deps)
+		vendorProject(project, deps)
+		shutil.Exit(0)
+
 	case "which":
-		var printRoot bool
+		var printRoot, printWorkspace bool
 		which := flag.NewFlagSet("which", flag.ExitOnError)
 		which.Usage = printHelpWhich
 		which.BoolVar(&printRoot, "root", false, "")
+		which.BoolVar(&printWorkspace, "workspace", false, "")
 		which.Parse(args)
 		args := which.Args()
 		if len(args) != 1 {
 			shutil.Stderr("error: 'which' expects a single path\n")
 			shutil.Exit(1)
+		} else if printRoot && printWorkspace {
+			shutil.Stderr("error: only one of '--root' or '--workspace' can be used\n")
+			shutil.Exit(1)
 		}
 
 		// Check the target path
@@ -127,6 +376,8 @@ func main() {
 		message := "[" + cfg.Package.Name + "] "
 		if printRoot {
 			message += cfg.Package.Root
+		} else if printWorkspace {
+			message += cfg.Workspace
 		} else {
 			message += cfg.Project
 		}
@@ -140,12 +391,40 @@ func main() {
 		}
 
 		switch args[0] {
+		case "add":
+			printHelpAdd()
 		case "build":
 			printHelpBuild()
+		case "cache":
+			printHelpCache()
+		case "clean":
+			printHelpClean()
 		case "exec":
 			printHelpExec()
+		case "graph":
+			printHelpGraph()
+		case "init":
+			printHelpInit()
+		case "mod":
+			printHelpMod()
+		case "exclude":
+			printHelpExclude()
+		case "patch":
+			printHelpPatch()
 		case "publish":
 			printHelpPublish()
+		case "remove":
+			printHelpRemove()
+		case "run":
+			printHelpRun()
+		case "test":
+			printHelpTest()
+		case "update":
+			printHelpUpdate()
+		case "vendor":
+			printHelpVendor()
+		case "resolvers":
+			printHelpResolvers()
 		case "which":
 			printHelpWhich()
 		default:
@@ -167,6 +446,13 @@ func main() {
 func syncProject(pwd string) *Config {
 	defer debug.TimedFunction(time.Now(), "syncProject("+pwd+")")
 
+	cfg := loadProject()
+	deps := loadDependencies(cfg)
+	syncWorkspace(cfg, deps)
+	return cfg
+}
+
+func loadProject() *Config {
 	//  Read the config file
 	cfg, err := discoverPackage(".", "./Bottle.toml", true)
 	if err != nil {
@@ -174,23 +460,99 @@ func syncProject(pwd string) *Config {
 		shutil.Stderr(err.Error() + "\n")
 		shutil.Exit(1)
 	}
+	// NOTE: This isn't checked by discoverPackage, because the workspace of
+	//       a dependency's config isn't used
+	if err := checkWorkspaceDir(cfg); err != nil {
+		shutil.Stderr("error: " + err.Error() + "\n")
+		shutil.Exit(1)
+	}
 
 	shutil.Cd(cfg.Project)
 	os.Setenv("GOPATH", cfg.Workspace)
+	return cfg
+}
 
-	// Discover, fetch, and install dependencies
-	deps := NewDependencyTracker(cfg)
-	err = deps.ResolveAll()
+// loadDependencies is like NewDependencyTracker, but exits if the project's
+// dependencies conflict.
+func loadDependencies(cfg *Config) *DependencyTracker {
+	deps, err := NewDependencyTracker(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return deps
+}
+
+func syncWorkspace(cfg *Config, deps *DependencyTracker) {
+	if err := trySyncWorkspace(cfg, deps); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// trySyncWorkspace is like syncWorkspace, but returns an error instead of
+// exiting (eg. so that "bottle add" can restore the config).
+func trySyncWorkspace(cfg *Config, deps *DependencyTracker) (err error) {
+	defer debug.TimedFunction(time.Now(), "syncWorkspace()")
+
+	// Don't let other bottle processes use the workspace while it is synced
+	lock, err := lockWorkspace(cfg.Workspace, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil && lock != nil {
+			lock.Unlock()
+		}
+	}()
+
+	// Discover, fetch, and install dependencies
+	lockfile := shutil.Path(cfg.Project, "Bottle.lock")
+	if !cfg.Missing {
+		err = deps.ReadLockfile(lockfile)
+		if err != nil {
+			return err
+		}
+	}
+	// Stop resolving (and clean up any partial clones) when interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = deps.ResolveAll(ctx)
+	stop()
+	if err != nil {
+		return err
+	}
+	for _, importPath := range deps.UnusedPatches() {
+		shutil.Stderr("warning: the patch for \"" + importPath + "\" doesn't match any dependency\n")
+	}
 	err = deps.InstallAll()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if !cfg.Missing {
+		err = deps.WriteLockfile(lockfile)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Copy this project into the workspace
-	pathResolver(cfg.Package.Root, cfg.Package.Name, cfg.Workspace)
+	exclude, err := newExcludeList(cfg.Package.Root, cfg.Package.Exclude, cfg.Package.Gitignore)
+	if err != nil {
+		return err
+	}
+	changes, err := copyPackage(cfg.Package.Root, cfg.Package.Name, cfg.Workspace, exclude)
+	if err != nil {
+		return err
+	}
+	rewritten, err := applyRenames(shutil.Path(cfg.Workspace, "src", cfg.Package.Name), configRenames(cfg))
+	if err != nil {
+		return err
+	}
+	err = recordRenames(cfg, changes, rewritten)
+	if err != nil {
+		return err
+	}
 
-	return cfg
+	// Keep other processes from syncing the workspace while this one uses it
+	lock.Downgrade()
+	heldLock, lock = lock, nil
+	return nil
 }
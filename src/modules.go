@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bottle/debug"
+	"bottle/gomod"
+	"bottle/semver"
+	"bottle/shutil"
+)
+
+// loadGoMod adds the requirements of a Go module to the config of a package
+// that doesn't have a "Bottle.toml".  Replace directives are ignored, in the
+// same way that the go tool ignores them in the main module's dependencies.
+//
+// Modules with a major version suffix (eg. "example.com/a/v2") are resolved
+// from the root of their repository, so they are only found if the repository
+// uses a "v2" subdirectory.
+func loadGoMod(cfg *Config, mod *gomod.File) {
+	cfg.Module = mod.Module
+	cfg.ImportPath = mod.Module
+	cfg.Package.Name = mod.Module
+	if cfg.Dependencies == nil {
+		cfg.Dependencies = make(map[string]configDependency)
+	}
+	for _, require := range mod.Require {
+		cfg.Dependencies[require.Path] = goModDependency(require)
+	}
+}
+
+// goModDependency converts a module requirement into a dependency.  The go
+// tool would select the lowest version that satisfies every requirement, but
+// bottle selects the highest version with the same major version.
+func goModDependency(require gomod.Require) configDependency {
+	if rev, ok := gomod.PseudoRevision(require.Version); ok {
+		return configDependency{Rev: rev}
+	}
+
+	version, err := semver.Parse(strings.TrimSuffix(require.Version, "+incompatible"))
+	if err != nil {
+		return configDependency{Tag: require.Version}
+	}
+	return configDependency{Version: fmt.Sprintf(">=%s, <%d.0.0", version, version.Major+1)}
+}
+
+// GoMod describes the project and its resolved dependencies as a Go module.
+// Each "path" dependency is replaced by its directory.  It also returns
+// warnings about dependencies which can't be described exactly.
+func (deps *DependencyTracker) GoMod() (*gomod.File, []string) {
+	defer debug.TimedFunction(time.Now(), "DependencyTracker.GoMod()")
+
+	root := deps.rootConfig.Package.Root
+	mod := &gomod.File{Module: deps.rootConfig.Package.Name, Go: goVersion(root)}
+	var warnings []string
+
+	var resolved []Dependency
+	for dep := range deps.revisions {
+		resolved = append(resolved, dep)
+	}
+	sort.Slice(resolved, func(i, j int) bool {
+		return deps.canonicalPaths[resolved[i]] < deps.canonicalPaths[resolved[j]]
+	})
+
+	for _, dep := range resolved {
+		importPath := deps.canonicalPaths[dep]
+		require := gomod.Require{Path: importPath, Indirect: !deps.isDirect(importPath)}
+		if dep.Protocol == "path" {
+			dir := filepath.ToSlash(shutil.Relpath(root, dep.Repository))
+			if !strings.HasPrefix(dir, ".") {
+				dir = "./" + dir
+			}
+			if !shutil.IsRegularFile(shutil.Path(dep.Repository, "go.mod")) {
+				warnings = append(warnings, fmt.Sprintf(`"%s" is replaced by "%s", which doesn't have a go.mod`, importPath, dir))
+			}
+			require.Version = "v0.0.0"
+			mod.Replace = append(mod.Replace, gomod.Replace{Old: importPath, New: dir})
+		} else {
+			version, err := deps.moduleVersion(dep)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf(`"%s" was left out: %s`, importPath, err))
+				continue
+			}
+			require.Version = version
+		}
+		mod.Require = append(mod.Require, require)
+	}
+	return mod, warnings
+}
+
+// isDirect returns whether a dependency (or one of its subpackages) is
+// listed in the project's config.
+func (deps *DependencyTracker) isDirect(importPath string) bool {
+	for dependency := range deps.rootConfig.Dependencies {
+		if dependency == importPath || strings.HasPrefix(dependency, importPath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// moduleVersion returns the module version of a resolved dependency, which is
+// its tag if it was selected by a version (or a tag which is a version), or
+// else a pseudo-version of its commit.
+func (deps *DependencyTracker) moduleVersion(dep Dependency) (string, error) {
+	dest := shutil.Path(deps.rootConfig.Workspace, "src", deps.canonicalPaths[dep])
+
+	tag := deps.versions[dep]
+	if len(tag) == 0 {
+		tag = deps.requested[dep].Tag
+	}
+	if version, err := semver.Parse(tag); err == nil {
+		// NOTE: A v2+ version of a package without a go.mod is "incompatible"
+		version.Build = ""
+		suffix := ""
+		if version.Major >= 2 && !shutil.IsRegularFile(shutil.Path(dest, "go.mod")) {
+			suffix = "+incompatible"
+		}
+		return "v" + version.String() + suffix, nil
+	}
+
+	repo, vcs := findRepositoryRoot(dest, shutil.Path(deps.rootConfig.Workspace, "src"))
+	if vcs != "git" {
+		return "", fmt.Errorf(`a pseudo-version can only be computed for a git repository`)
+	}
+	revision := deps.revisions[dep]
+	timestamp, err := git(context.Background(), repo, `show`, `--no-patch`, `--format=%ct`, revision)
+	if err != nil {
+		return "", err
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf(`unexpected commit time "%s"`, timestamp)
+	}
+	return gomod.PseudoVersion(time.Unix(seconds, 0), revision), nil
+}
+
+// goVersion returns the "go" version of an existing go.mod in a directory,
+// or else the language version of the installed go tool.
+func goVersion(dir string) string {
+	modpath := shutil.Path(dir, "go.mod")
+	if shutil.IsRegularFile(modpath) {
+		if mod, err := gomod.Parse(shutil.Binread(modpath)); err == nil && len(mod.Go) > 0 {
+			return mod.Go
+		}
+	}
+
+	output, err := shutil.Cmd(`go`, `env`, `GOVERSION`).Try()
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(output), "go"), ".")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[0] + "." + parts[1]
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"bottle/shutil"
+)
+
+// offline forbids the resolvers from accessing the network, so dependencies
+// can only be resolved from the workspace and the cache.  It is set by the
+// "--offline" option, or by setting BOTTLE_OFFLINE in the environment.
+var offline bool
+
+func offlineFromEnv() bool {
+	value := shutil.Env()["BOTTLE_OFFLINE"]
+	return len(value) > 0 && value != "0" && value != "false"
+}
+
+// OfflineError is returned by a resolver when a package can't be resolved
+// without accessing the network.
+type OfflineError struct {
+	Package string
+	Reason  string
+}
+
+func (err *OfflineError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Package, err.Reason)
+}
+
+func describeRevision(rev Revision) string {
+	if len(rev.String()) == 0 {
+		return "the default branch"
+	}
+	return rev.String()
+}
+
+// missingError reports every package which couldn't be resolved offline.
+func missingError(missing []*OfflineError) error {
+	var lines []string
+	for _, err := range missing {
+		lines = append(lines, err.Error())
+	}
+	sort.Strings(lines)
+	return fmt.Errorf("Can't resolve %d dependencies while offline:\n\n\t%s\n\n"+
+		"Run bottle without \"--offline\" (or BOTTLE_OFFLINE) to fetch them.\n",
+		len(lines), strings.Join(lines, "\n\t"))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// The [patch] table of the root config replaces the source of a dependency
+// wherever it appears in the dependency graph, including in the configs of
+// other dependencies.  This allows a fix to a deep dependency to be tested
+// without editing the configs of the packages between it and the project.
+//
+//   [patch]
+//   "github.com/a/lib" = { path = "../lib" }
+//   "example.com/b" = { git = "https://github.com/me/b.git", branch = "fix" }
+//
+// A patch also applies to the subpackages of its import path.  The [patch]
+// tables of dependencies are ignored.
+
+// findPatch returns the longest import path in the root config's [patch]
+// table which matches a dependency's import path, and its source.
+func (deps *DependencyTracker) findPatch(importPath string) (string, configDependency, bool) {
+	found := ""
+	for patched := range deps.rootConfig.Patch {
+		if (importPath == patched || strings.HasPrefix(importPath, patched+"/")) && len(patched) > len(found) {
+			found = patched
+		}
+	}
+	if len(found) == 0 {
+		return "", configDependency{}, false
+	}
+	deps.patched[found] = true
+	return found, deps.rootConfig.Patch[found], true
+}
+
+// UnusedPatches returns the import paths in the [patch] table which didn't
+// match any dependency.
+func (deps *DependencyTracker) UnusedPatches() []string {
+	var unused []string
+	for patched := range deps.rootConfig.Patch {
+		if !deps.patched[patched] {
+			unused = append(unused, patched)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"bottle/debug"
+	"bottle/shutil"
+)
+
+const (
+	pluginPrefix          = "bottle-resolver-"
+	pluginAlreadyResolved = 100 // exit status of a plugin if the package was already resolved
+)
+
+// findResolver returns the resolver for a protocol.  If it isn't a builtin
+// protocol, it looks for an executable configured in the user's config, and
+// then for an executable named "bottle-resolver-<protocol>" in the PATH.
+//
+// Except for "git" (which is cached as a mirror), "go-get" and "path", the
+// resolved packages are saved as snapshots in the cache.
+func findResolver(protocol string) (ResolverFunc, error) {
+	if resolver, exists := builtinResolvers[protocol]; exists {
+		switch protocol {
+		case "git", "go-get", "path":
+			return resolver, nil
+		}
+		return withSnapshots(protocol, resolver), nil
+	}
+	if executable, exists := userConfig.Resolvers[protocol]; exists {
+		return withSnapshots(protocol, pluginResolver(protocol, executable)), nil
+	}
+	if executable, err := exec.LookPath(pluginPrefix + protocol); err == nil {
+		return withSnapshots(protocol, pluginResolver(protocol, executable)), nil
+	}
+	return nil, fmt.Errorf(`No resolver exists for protocol "%s" (expected an executable named "%s" in the PATH, or in the [resolvers] section of "%s")`,
+		protocol, pluginPrefix+protocol, userConfigPath())
+}
+
+// pluginResolver returns a resolver which runs an external executable.  See
+// "bottle help resolvers" for a description of the executable's contract.
+func pluginResolver(protocol string, executable string) ResolverFunc {
+	return func(ctx context.Context, src string, rev Revision, pkg string, workspace string) (string, error) {
+		defer debug.TimedFunction(time.Now(), "pluginResolver("+protocol+", "+src+")")
+
+		dest := shutil.Path(workspace, "src", pkg)
+		defer lockDestination(dest)()
+		shutil.MkdirParents(shutil.Path(workspace, "src"), 0755)
+
+		var stdout, stderr bytes.Buffer
+		created := !shutil.Exists(dest)
+		cmd := shutil.CmdContext(ctx, executable, src, pkg, workspace)
+		cmd.Env = append(cmd.Env,
+			"BOTTLE_PROTOCOL="+protocol,
+			"BOTTLE_REV="+rev.Rev,
+			"BOTTLE_TAG="+rev.Tag,
+			"BOTTLE_BRANCH="+rev.Branch,
+			"BOTTLE_DEST="+dest,
+		)
+		if rev.Refresh {
+			cmd.Env = append(cmd.Env, "BOTTLE_REFRESH=1")
+		}
+		if offline {
+			cmd.Env = append(cmd.Env, "BOTTLE_OFFLINE=1")
+		}
+		cmd.Dir = workspace
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := cmd.Cmd.Run()
+
+		resolved := err == nil
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == pluginAlreadyResolved {
+			err = AlreadyResolved
+		}
+		if err != nil && err != AlreadyResolved {
+			if created {
+				removeIncomplete(dest, err)
+			}
+			tabbedOutput := strings.Join(strings.Split(strings.TrimSpace(stderr.String()), "\n"), "\n\t")
+			return "", fmt.Errorf("resolver: error running \"%s\" for %s (%s):\n\n\t%s\n", executable, pkg, err, tabbedOutput)
+		}
+		if !shutil.IsDirectory(dest) {
+			return "", fmt.Errorf(`resolver: "%s" did not create the package directory "%s"`, executable, dest)
+		}
+
+		// The revision is the last line of output, or else a hash of the package
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		revision := strings.TrimSpace(lines[len(lines)-1])
+		if len(revision) == 0 {
+			revision, err = hashTree(dest)
+			if err != nil {
+				return "", err
+			}
+		}
+		if !resolved {
+			return revision, AlreadyResolved
+		}
+		return revision, nil
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// forwardedSignals are the signals which "bottle run" forwards to the program.
+// NOTE: A process can't be sent signals on Windows (except to kill it)
+var forwardedSignals []os.Signal
+
+const stillActive = 259 // NOTE: STILL_ACTIVE, the exit code of a running process
+
+// processExists returns whether a process is running.
+func processExists(pid int) bool {
+	process, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err == syscall.ERROR_ACCESS_DENIED {
+		return true
+	} else if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(process)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(process, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
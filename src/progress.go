@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"bottle/shutil"
+)
+
+// progress shows the dependencies which are being resolved.  On a terminal,
+// the running resolvers are redrawn in place with how long they have taken;
+// otherwise a line is printed for each resolver which takes a long time.  In
+// both cases, a line is printed for each dependency which was fetched.
+type progress struct {
+	out   io.Writer
+	tty   bool
+	width int
+
+	mutex    sync.Mutex
+	tasks    []*progressTask // the running resolvers, in the order they started
+	drawn    int             // the number of lines in the live display
+	finished int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type progressTask struct {
+	importPath string
+	source     string
+	start      time.Time
+	reported   time.Time // when the task was last reported, if not on a terminal
+}
+
+// progressSlowInterval is how often a running resolver is reported, if not on
+// a terminal.
+const progressSlowInterval = 10 * time.Second
+
+func startProgress(out *os.File) *progress {
+	p := &progress{
+		out:   out,
+		tty:   isTerminal(out),
+		width: terminalWidth(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *progress) run() {
+	defer close(p.done)
+
+	interval := time.Second
+	if p.tty {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mutex.Lock()
+			if p.tty {
+				p.draw()
+			} else {
+				p.reportSlow()
+			}
+			p.mutex.Unlock()
+		}
+	}
+}
+
+// Start adds a running resolver to the display.
+func (p *progress) Start(importPath, source string) *progressTask {
+	now := time.Now()
+	task := &progressTask{importPath: importPath, source: source, start: now, reported: now}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.tasks = append(p.tasks, task)
+	return task
+}
+
+// Finish removes a resolver from the display, and prints a line if it
+// fetched a new revision of the dependency.
+func (p *progress) Finish(task *progressTask, fetched bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i, other := range p.tasks {
+		if other == task {
+			p.tasks = append(p.tasks[:i], p.tasks[i+1:]...)
+			break
+		}
+	}
+	p.finished += 1
+	if fetched {
+		p.println(fmt.Sprintf("Fetched %s (%s) in %s", task.importPath, task.source, formatElapsed(time.Since(task.start))))
+	}
+}
+
+// Stop removes the live display.
+func (p *progress) Stop() {
+	close(p.stop)
+	<-p.done
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.clear()
+}
+
+// println prints a line above the live display.
+func (p *progress) println(line string) {
+	p.clear()
+	fmt.Fprintln(p.out, line)
+	p.draw()
+}
+
+func (p *progress) clear() {
+	if p.drawn > 0 {
+		fmt.Fprintf(p.out, "\x1b[%dA\x1b[J", p.drawn)
+		p.drawn = 0
+	}
+}
+
+func (p *progress) draw() {
+	if !p.tty {
+		return
+	}
+	p.clear()
+	if len(p.tasks) == 0 {
+		return
+	}
+
+	lines := []string{fmt.Sprintf("Resolving dependencies (%d done, %d running)", p.finished, len(p.tasks))}
+	for _, task := range p.tasks {
+		lines = append(lines, fmt.Sprintf("  %s (%s) %s", task.importPath, task.source, formatElapsed(time.Since(task.start))))
+	}
+	for _, line := range lines {
+		// NOTE: Lines which wrap would break moving the cursor back up
+		if runes := []rune(line); len(runes) >= p.width {
+			line = string(runes[:p.width-4]) + "..."
+		}
+		fmt.Fprintln(p.out, line)
+	}
+	p.drawn = len(lines)
+}
+
+func (p *progress) reportSlow() {
+	for _, task := range p.tasks {
+		if time.Since(task.reported) >= progressSlowInterval {
+			task.reported = time.Now()
+			fmt.Fprintf(p.out, "Still resolving %s (%s) after %s\n", task.importPath, task.source, formatElapsed(time.Since(task.start)))
+		}
+	}
+}
+
+func formatElapsed(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
+
+// isTerminal returns whether a file is a terminal which supports moving the
+// cursor.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0 && shutil.Env()["TERM"] != "dumb"
+}
+
+func terminalWidth() int {
+	if width, err := strconv.Atoi(shutil.Env()["COLUMNS"]); err == nil && width > 10 {
+		return width
+	}
+	return 80
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bottle/filesync"
+	"bottle/shutil"
+	"bottle/toml"
+)
+
+// Dependencies with an "as" path are put in the workspace at that path, and
+// the imports of their original path are rewritten in the workspace copy of
+// each package that depends on them (and in the dependency itself, for its
+// imports of its own subpackages).  This allows two forks or major versions
+// of a package to be used by different packages in the same project.
+//
+//   [dependencies]
+//   "github.com/a/lib" = { git = "https://github.com/me/lib.git", as = "github.com/me/lib" }
+//
+// The source directories of "path" dependencies and of the project are never
+// modified.  The imports which were rewritten in the workspace copy of the
+// project are recorded in the workspace's ".bottle-renames", so that only
+// those imports are restored before a tool's changes are copied back.
+
+// configRenames returns the import paths which are renamed by a config.
+func configRenames(cfg *Config) map[string]string {
+	renames := make(map[string]string)
+	for importPath, meta := range cfg.Dependencies {
+		if len(meta.As) > 0 {
+			renames[importPath] = meta.As
+		}
+	}
+	return renames
+}
+
+// renameImportPath returns the new import path of a package, if it is (or is
+// a subpackage of) one of the renamed import paths.  The longest matching
+// import path is used, so that a renamed subpackage of a renamed package
+// gets its own new path.  An import path which is already one of the new
+// paths (or a subpackage of one) is left alone, since the same files are
+// renamed again each time the workspace is synchronized, and a new path may
+// extend its original (eg. "github.com/a/lib" as "github.com/a/lib/v2").
+func renameImportPath(importPath string, renames map[string]string) (string, bool) {
+	for _, renamed := range renames {
+		if importPath == renamed || strings.HasPrefix(importPath, renamed+"/") {
+			return importPath, false
+		}
+	}
+
+	match := ""
+	for original := range renames {
+		if (importPath == original || strings.HasPrefix(importPath, original+"/")) && len(original) > len(match) {
+			match = original
+		}
+	}
+	if len(match) == 0 {
+		return importPath, false
+	}
+	return renames[match] + importPath[len(match):], true
+}
+
+// renamedImport is an import in a Go file which was rewritten by applyRenames.
+type renamedImport struct {
+	File     string // relative to the package's directory, with forward slashes
+	Original string
+	Renamed  string
+}
+
+// renameRecord is the format of a workspace's ".bottle-renames".
+type renameRecord struct {
+	Import []renamedImport
+}
+
+// applyRenames rewrites the imports in every Go file in a package directory
+// and its subdirectories, except for hidden, "testdata" and "vendor"
+// directories, and returns the imports which were rewritten.
+func applyRenames(dir string, renames map[string]string) ([]renamedImport, error) {
+	if len(renames) == 0 {
+		return nil, nil
+	}
+
+	var rewritten []renamedImport
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if path != dir && (strings.HasPrefix(name, ".") || name == "testdata" || name == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !strings.HasSuffix(name, ".go") {
+			return nil
+		}
+
+		rel := filepath.ToSlash(shutil.Relpath(dir, path))
+		return rewriteImports(path, info, func(importPath string) (string, bool) {
+			renamed, ok := renameImportPath(importPath, renames)
+			if ok {
+				rewritten = append(rewritten, renamedImport{File: rel, Original: importPath, Renamed: renamed})
+			}
+			return renamed, ok
+		})
+	})
+	return rewritten, err
+}
+
+// rewriteImports replaces the import paths in a Go file for which "rename"
+// returns true.  Only the import path literals are replaced, so the rest of
+// the file is unchanged, and the modification time is preserved so that the
+// rewritten file isn't treated as newer than its source when the workspace is
+// synchronized.
+func rewriteImports(filename string, info os.FileInfo, rename func(string) (string, bool)) error {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ImportsOnly)
+	if err != nil {
+		return fmt.Errorf("Failed to rename imports:\n\n\t%s\n", err)
+	}
+
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		if renamed, ok := rename(importPath); ok {
+			start := fset.Position(spec.Path.Pos()).Offset
+			end := fset.Position(spec.Path.End()).Offset
+			edits = append(edits, edit{start, end, strconv.Quote(renamed)})
+		}
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+
+	// Apply the edits from the end of the file, so the offsets stay valid
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	for _, e := range edits {
+		src = append(src[:e.start], append([]byte(e.text), src[e.end:]...)...)
+	}
+	if err := ioutil.WriteFile(filename, src, info.Mode()); err != nil {
+		return err
+	}
+	return os.Chtimes(filename, info.ModTime(), info.ModTime())
+}
+
+// recordRenames updates the record of the imports which are rewritten in the
+// workspace copy of the project.  The files which were copied again (or
+// removed) by the sync have lost their earlier rewrites.
+func recordRenames(cfg *Config, changes []filesync.Change, rewritten []renamedImport) error {
+	copied := make(map[string]bool)
+	for _, change := range changes {
+		copied[change.Path] = true
+	}
+
+	record, err := readRenames(cfg)
+	if err != nil {
+		return err
+	}
+	var imports []renamedImport
+	for _, renamed := range record.Import {
+		if !copied[renamed.File] {
+			imports = append(imports, renamed)
+		}
+	}
+	return writeRenames(cfg, append(imports, rewritten...))
+}
+
+// restoreRenames undoes the recorded renames in the workspace copy of the
+// project, so that changes made by a tool can be copied back to the source
+// directory.  Imports which weren't rewritten by bottle (eg. ones added by
+// the tool) are left alone.
+func restoreRenames(cfg *Config) error {
+	record, err := readRenames(cfg)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]map[string]string)
+	for _, renamed := range record.Import {
+		if files[renamed.File] == nil {
+			files[renamed.File] = make(map[string]string)
+		}
+		files[renamed.File][renamed.Renamed] = renamed.Original
+	}
+
+	dir := shutil.Path(cfg.Workspace, "src", cfg.Package.Name)
+	for rel, restore := range files {
+		filename := shutil.Path(dir, filepath.FromSlash(rel))
+		info, err := os.Stat(filename)
+		if os.IsNotExist(err) {
+			continue // NOTE: the tool removed the file
+		} else if err != nil {
+			return err
+		}
+		err = rewriteImports(filename, info, func(importPath string) (string, bool) {
+			original, ok := restore[importPath]
+			return original, ok
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return writeRenames(cfg, nil)
+}
+
+func renamesFile(cfg *Config) string {
+	return shutil.Path(cfg.Workspace, ".bottle-renames")
+}
+
+func readRenames(cfg *Config) (renameRecord, error) {
+	var record renameRecord
+	filename := renamesFile(cfg)
+	if !shutil.Exists(filename) {
+		return record, nil
+	}
+	if err := toml.Unmarshal(shutil.Binread(filename), &record); err != nil {
+		return record, fmt.Errorf("Failed to read \"%s\":\n\n\t%s\n", filename, err)
+	}
+	return record, nil
+}
+
+func writeRenames(cfg *Config, imports []renamedImport) error {
+	filename := renamesFile(cfg)
+	if len(imports) == 0 {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := toml.Marshal(renameRecord{Import: imports})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bottle/shutil"
+)
+
+func TestRenameImportPath(t *testing.T) {
+	renames := map[string]string{
+		"github.com/a/lib":     "github.com/a/lib/v2",
+		"github.com/b/fork":    "github.com/me/fork",
+		"github.com/b/fork/cs": "github.com/me/cs",
+	}
+	tests := []struct {
+		importPath string
+		want       string
+		ok         bool
+	}{
+		{"github.com/a/lib", "github.com/a/lib/v2", true},
+		{"github.com/a/lib/sub", "github.com/a/lib/v2/sub", true},
+		{"github.com/a/lib/v2", "github.com/a/lib/v2", false},
+		{"github.com/a/lib/v2/sub", "github.com/a/lib/v2/sub", false},
+		{"github.com/a/library", "github.com/a/library", false},
+		{"github.com/b/fork/x", "github.com/me/fork/x", true},
+		{"github.com/b/fork/cs/y", "github.com/me/cs/y", true},
+		{"github.com/me/fork", "github.com/me/fork", false},
+		{"fmt", "fmt", false},
+	}
+	for _, test := range tests {
+		got, ok := renameImportPath(test.importPath, renames)
+		if got != test.want || ok != test.ok {
+			t.Errorf(`renameImportPath("%s") => "%s", %v; want "%s", %v`, test.importPath, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestApplyRenames(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "bottle-renames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+
+	cfg := &Config{Workspace: workspace}
+	cfg.Package.Name = "app"
+	dir := shutil.Path(workspace, "src", "app")
+	if err := os.MkdirAll(filepath.Join(dir, "cmd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	const original = "package cmd\n\nimport (\n\t\"fmt\"\n\n\t\"github.com/a/lib\"\n\t\"github.com/a/lib/sub\"\n)\n"
+	const renamed = "package cmd\n\nimport (\n\t\"fmt\"\n\n\t\"github.com/a/lib/v2\"\n\t\"github.com/a/lib/v2/sub\"\n)\n"
+	filename := filepath.Join(dir, "cmd", "main.go")
+	if err := ioutil.WriteFile(filename, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	renames := map[string]string{"github.com/a/lib": "github.com/a/lib/v2"}
+
+	// NOTE: Each sync renames the imports of the whole workspace copy again
+	for sync := 1; sync <= 2; sync++ {
+		rewritten, err := applyRenames(dir, renames)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 2
+		if sync > 1 {
+			want = 0
+		}
+		if len(rewritten) != want {
+			t.Errorf(`sync %d: applyRenames rewrote %d imports; want %d`, sync, len(rewritten), want)
+		}
+		if err := recordRenames(cfg, nil, rewritten); err != nil {
+			t.Fatal(err)
+		}
+		if got := string(shutil.Binread(filename)); got != renamed {
+			t.Errorf("sync %d: applyRenames wrote:\n%s\nwant:\n%s", sync, got, renamed)
+		}
+	}
+
+	record, err := readRenames(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Import) != 2 || record.Import[0].File != "cmd/main.go" || record.Import[0].Original != "github.com/a/lib" {
+		t.Errorf(`recorded renames: %+v; want the 2 imports of "cmd/main.go"`, record.Import)
+	}
+
+	if err := restoreRenames(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(shutil.Binread(filename)); got != original {
+		t.Errorf("restoreRenames wrote:\n%s\nwant:\n%s", got, original)
+	}
+	if shutil.Exists(renamesFile(cfg)) {
+		t.Errorf(`restoreRenames left "%s"`, renamesFile(cfg))
+	}
+}
@@ -1,74 +1,172 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	"bottle/debug"
+	"bottle/filesync"
 	"bottle/shutil"
 )
 
-type ResolverFunc func(src string, pkg string, workspace string) error
+// ResolverFunc fetches the package at "src" into "workspace/src/pkg".  If "rev"
+// is not empty, the package is checked out at that revision.  It returns the
+// exact revision of the package that is in the workspace.  If the context is
+// cancelled, the resolver stops and removes any package it was creating.
+type ResolverFunc func(ctx context.Context, src string, rev Revision, pkg string, workspace string) (string, error)
 type ResolverList map[string]ResolverFunc
 
 var AlreadyResolved = fmt.Errorf("Return this error if the package has already been resolved")
 
+// Revision selects which commit of a repository should be checked out.  At
+// most one field is set; if none are set the remote's default branch is used.
+type Revision struct {
+	Rev    string // a commit hash (or any other commit-ish)
+	Tag    string
+	Branch string
+
+	// A semantic version constraint, which is resolved to a tag before the
+	// revision is passed to a resolver
+	Version string
+
+	// Whether to fetch the latest changes from the remote, even if the
+	// package already exists in the workspace
+	Refresh bool
+}
+
+func (rev Revision) String() string {
+	switch {
+	case len(rev.Rev) > 0:
+		return "rev " + rev.Rev
+	case len(rev.Tag) > 0:
+		return "tag " + rev.Tag
+	case len(rev.Branch) > 0:
+		return "branch " + rev.Branch
+	case len(rev.Version) > 0:
+		return "version " + rev.Version
+	}
+	return ""
+}
+
 var builtinResolvers = ResolverList{
 	"go-get": goGetResolver,
 	"git":    gitResolver,
+	"hg":     hgResolver,
+	"svn":    svnResolver,
+	"bzr":    bzrResolver,
 	"path":   pathResolver,
 }
 
+// vcsResolvers are used to resolve "go-get" dependencies, by the VCS in the
+// package's go-import meta tag
+var vcsResolvers = ResolverList{
+	"git": gitResolver,
+	"hg":  hgResolver,
+	"svn": svnResolver,
+	"bzr": bzrResolver,
+}
+
 var goImportMetaTag = regexp.MustCompile(`<meta\s[^>]*name\s*=\s*("go-import"|'go-import'|go-import)[^>]*>`)
 var goImportMetaContent = regexp.MustCompile(`content\s*=\s*("[^\s"]+ [^\s"]+ [^\s"]+"|'[^\s']+ [^\s']+ [^\s']+')`)
 
 type goImportMeta struct{ prefix, vcs, repo string }
 
 // See https://golang.org/cmd/go/#hdr-Remote_import_paths
-func goGetResolver(src string, pkg string, workspace string) error {
+func goGetResolver(ctx context.Context, src string, rev Revision, pkg string, workspace string) (string, error) {
 	defer debug.TimedFunction(time.Now(), "goGetResolver("+src+")")
 
 	// Don't re-resolve the package if we already have it
-	if shutil.Exists(shutil.Path(workspace, "src", pkg)) {
-		return AlreadyResolved
+	dest := shutil.Path(workspace, "src", pkg)
+	if shutil.Exists(dest) {
+		if root, vcs := findRepositoryRoot(dest, shutil.Path(workspace, "src")); len(root) > 0 {
+			prefix := filepath.ToSlash(shutil.Relpath(shutil.Path(workspace, "src"), root))
+			return vcsResolvers[vcs](ctx, "", rev, prefix, workspace)
+		}
+
+		revision, err := hashTree(dest)
+		if err != nil {
+			return "", err
+		}
+		return revision, AlreadyResolved
 	}
 
-	// Use HTTP to fetch the package metadata
-	meta, err := goGetMeta(src)
+	meta, err := resolveGoGetMeta(ctx, src)
 	if err != nil {
-		return err
+		return "", err
 	}
-	if meta.prefix != src {
-		parentMeta, err := goGetMeta(src)
-		if err != nil {
-			return err
+
+	// Resolve the package with the appropriate VCS
+	resolver, exists := vcsResolvers[meta.vcs]
+	if !exists {
+		return "", fmt.Errorf(`resolver: unknown VCS "%s" when resolving remote import "%s"`, meta.vcs, src)
+	}
+	return resolver(ctx, meta.repo, rev, meta.prefix, workspace)
+}
+
+// findRepositoryRoot returns the nearest directory containing "dir" which is
+// the root of a repository, and the repository's VCS, without searching above
+// the "stop" directory.
+func findRepositoryRoot(dir, stop string) (string, string) {
+	for shutil.IsSubdir(dir, stop) && dir != stop {
+		for _, vcs := range []string{"git", "hg", "svn", "bzr"} {
+			if shutil.Exists(shutil.Path(dir, "."+vcs)) {
+				return dir, vcs
+			}
 		}
-		if *parentMeta != *meta {
-			return fmt.Errorf(`resolver: go-import meta for "%s" does not match its prefix "%s"`, src, meta.prefix)
+		dir = shutil.Dirname(dir)
+	}
+	return "", ""
+}
+
+// resolveGoGetMeta returns the go-import meta of an import path, which is
+// fetched with HTTP and recorded in the cache, or read from the cache when
+// offline (so the package can be cloned from the cache's mirror).
+func resolveGoGetMeta(ctx context.Context, importPath string) (*goImportMeta, error) {
+	if offline {
+		if meta := cachedGoGetMeta(importPath); meta != nil {
+			return meta, nil
 		}
+		return nil, &OfflineError{Package: importPath, Reason: "the package is not in the workspace or cache"}
 	}
 
-	// Resolve the package with the appropriate VCS
-	switch meta.vcs {
-	case "git":
-		return gitResolver(meta.repo, meta.prefix, workspace)
-	default:
-		return fmt.Errorf(`resolver: unknown VCS "%s" when resolving remote import "%s"`, meta.vcs, src)
+	meta, err := goGetMeta(ctx, importPath)
+	if err != nil {
+		return nil, err
 	}
+	if meta.prefix != importPath {
+		parentMeta, err := goGetMeta(ctx, meta.prefix)
+		if err != nil {
+			return nil, err
+		}
+		if *parentMeta != *meta {
+			return nil, fmt.Errorf(`resolver: go-import meta for "%s" does not match its prefix "%s"`, importPath, meta.prefix)
+		}
+	}
+	saveGoGetMeta(importPath, meta)
+	return meta, nil
 }
-func goGetMeta(prefix string) (*goImportMeta, error) {
+
+func goGetMeta(ctx context.Context, prefix string) (*goImportMeta, error) {
 	url := "https://" + prefix + "?go-get=1"
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf(`resolver: %s`, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf(`resolver: %s`, err)
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf(`resolver: recevied status %d from "%s", expecting 200`, url)
+		return nil, fmt.Errorf(`resolver: recevied status %d from "%s", expecting 200`, resp.StatusCode, url)
 	}
 
 	data, err := ioutil.ReadAll(resp.Body)
@@ -90,73 +188,257 @@ func goGetMeta(prefix string) (*goImportMeta, error) {
 	return &goImportMeta{prefix: parts[0], vcs: parts[1], repo: parts[2]}, nil
 }
 
-var gitMutex sync.Mutex
-var gitMap = make(map[string]*sync.Cond)
+// listTags returns the names of the tags in a dependency's remote repository.
+func listTags(ctx context.Context, dep Dependency) ([]string, error) {
+	repo := dep.Repository
+	switch dep.Protocol {
+	case "git":
+	case "go-get":
+		meta, err := resolveGoGetMeta(ctx, dep.Repository)
+		if err != nil {
+			return nil, err
+		}
+		if meta.vcs != "git" {
+			return nil, fmt.Errorf(`resolver: can't list versions of "%s" with VCS "%s"`, dep.Repository, meta.vcs)
+		}
+		repo = meta.repo
+	default:
+		return nil, fmt.Errorf(`resolver: can't list versions of a "%s" dependency`, dep.Protocol)
+	}
+	if offline {
+		repo = gitMirrorPath(repo)
+		if !shutil.Exists(repo) {
+			return nil, &OfflineError{Package: dep.Repository, Reason: "the repository is not in the cache"}
+		}
+		unlock, err := lockCacheEntry(ctx, repo)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
 
-func gitResolver(src string, pkg string, workspace string) error {
-	defer debug.TimedFunction(time.Now(), "gitResolver("+src+")")
+	output, err := shutil.CmdContext(ctx, `git`, `ls-remote`, `--tags`, repo).Try()
+	if err != nil {
+		tabbedOutput := strings.Join(strings.Split(output, "\n"), "\n\t")
+		return nil, fmt.Errorf("resolver: error listing tags with git:\n\n\t%s\n", tabbedOutput)
+	}
 
-	dest := shutil.Path(workspace, "src", pkg)
+	var tags []string
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || strings.HasSuffix(fields[1], "^{}") {
+			continue
+		}
+		tags = append(tags, strings.TrimPrefix(fields[1], "refs/tags/"))
+	}
+	return tags, nil
+}
 
-	// Prevent multiple gitResolvers from cloning into the same directory concurrently
-	{
-		gitMutex.Lock()
-		if cond, ok := gitMap[dest]; ok {
-			gitMutex.Unlock()
-			cond.Wait()
-		} else {
-			mutex := new(sync.Mutex)
-			mutex.Lock()
-			gitMap[dest] = sync.NewCond(mutex)
-			gitMutex.Unlock()
+var destMutex sync.Mutex
+var destMap = make(map[string]*sync.Mutex)
 
-			defer func() {
-				gitMutex.Lock()
+// lockDestination prevents multiple resolvers from cloning into the same
+// directory concurrently.  It returns a function to release the lock.
+func lockDestination(dest string) func() {
+	destMutex.Lock()
+	mutex, ok := destMap[dest]
+	if !ok {
+		mutex = new(sync.Mutex)
+		destMap[dest] = mutex
+	}
+	destMutex.Unlock()
 
-				gitMap[dest].Broadcast()
-				delete(gitMap, dest)
+	mutex.Lock()
+	return mutex.Unlock
+}
 
-				gitMutex.Unlock()
-			}()
-		}
-	}
+func gitResolver(ctx context.Context, src string, rev Revision, pkg string, workspace string) (string, error) {
+	defer debug.TimedFunction(time.Now(), "gitResolver("+src+")")
+
+	dest := shutil.Path(workspace, "src", pkg)
+	defer lockDestination(dest)()
 
 	// Don't re-clone the repository if it already exists
 	if shutil.Exists(dest) {
 		if !shutil.Exists(shutil.Path(dest, ".git")) {
-			return fmt.Errorf("While resolving %s, directory \"%s\" exists but is not a Git repository", pkg, dest)
+			return "", fmt.Errorf("While resolving %s, directory \"%s\" exists but is not a Git repository", pkg, dest)
+		}
+
+		head, err := git(ctx, dest, `rev-parse`, `HEAD`)
+		if err != nil {
+			return "", err
+		}
+		if rev == (Revision{}) {
+			return head, AlreadyResolved
+		}
+
+		// Check to see if we are on the correct commit-ish, but always fetch
+		// branches because the remote-tracking branch is probably out of date
+		if len(rev.Branch) == 0 && !rev.Refresh {
+			commit, err := git(ctx, dest, `rev-parse`, `--verify`, `--quiet`, gitCommitish(rev)+"^{commit}")
+			if err == nil && commit == head {
+				return head, AlreadyResolved
+			}
 		}
 
-		// FIXME: Check to see if we are on the correct commit-ish
-		//        If not, do a "clean" checkout of the correct commit
-		return AlreadyResolved
+		// If not, do a "clean" checkout of the correct commit
+		if err := gitFetch(ctx, dest, rev); err != nil {
+			return "", err
+		}
+		commit, err := gitCheckout(ctx, dest, rev)
+		if err != nil && offline {
+			return "", &OfflineError{Package: pkg, Reason: describeRevision(rev) + " is not in the workspace or cache"}
+		}
+		if err == nil && commit == head {
+			return head, AlreadyResolved
+		}
+		return commit, err
 	}
 
 	// Actually clone the repository
-	output, err := shutil.Cmd(`git`, `clone`, src, dest).Try()
+	commit, err := gitClone(ctx, src, rev, dest)
+	removeIncomplete(dest, err)
+	return commit, err
+}
+
+// gitClone clones a repository (from a mirror in the cache) into "dest", and
+// checks out the revision.
+func gitClone(ctx context.Context, src string, rev Revision, dest string) (string, error) {
+	mirror, unlock, err := gitMirror(ctx, src, rev)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	output, err := shutil.CmdContext(ctx, `git`, `clone`, `--quiet`, `--config`, `bottle.upstream=`+src, mirror, dest).Try()
 	if err != nil {
 		tabbedOutput := strings.Join(strings.Split(output, "\n"), "\n\t")
-		return fmt.Errorf("resolver: error cloning repository with git:\n\n\t%s\n", tabbedOutput)
+		return "", fmt.Errorf("resolver: error cloning repository with git:\n\n\t%s\n", tabbedOutput)
 	}
+	if gitCommitish(rev) != gitDefaultBranch {
+		return gitCheckout(ctx, dest, rev)
+	}
+
+	return git(ctx, dest, `rev-parse`, `HEAD`)
+}
+
+// removeIncomplete removes a package directory which a resolver was creating,
+// if the resolver failed or was cancelled, so that a partial clone isn't
+// mistaken for a resolved package later.
+func removeIncomplete(dest string, err error) {
+	if err != nil && err != AlreadyResolved {
+		os.RemoveAll(dest)
+	}
+}
 
+// gitFetch fetches the revision into the repository at "dest".  If the
+// repository was cloned from a mirror in the cache, the mirror is updated
+// first (or re-created, if it has been pruned from the cache).  When offline,
+// a repository which wasn't cloned from a mirror isn't fetched at all.
+func gitFetch(ctx context.Context, dest string, rev Revision) error {
+	if upstream, err := git(ctx, dest, `config`, `--get`, `bottle.upstream`); err == nil && len(upstream) > 0 {
+		mirror, unlock, err := gitMirror(ctx, upstream, rev)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if _, err := git(ctx, dest, `remote`, `set-url`, `origin`, mirror); err != nil {
+			return err
+		}
+	} else if offline {
+		return nil
+	}
+
+	if _, err := git(ctx, dest, `fetch`, `--quiet`, `--tags`, `origin`); err != nil {
+		return err
+	}
+	if gitCommitish(rev) == gitDefaultBranch {
+		if _, err := git(ctx, dest, `remote`, `set-head`, `origin`, `--auto`); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func pathResolver(src string, pkg string, workspace string) error {
-	defer debug.TimedFunction(time.Now(), "pathResolver("+src+")")
+// gitCheckout does a clean checkout of the revision in the repository at
+// "dest", discarding any local changes, and returns the new commit hash.
+func gitCheckout(ctx context.Context, dest string, rev Revision) (string, error) {
+	if _, err := git(ctx, dest, `checkout`, `--quiet`, `--force`, `--detach`, gitCommitish(rev)); err != nil {
+		return "", err
+	}
+	if _, err := git(ctx, dest, `clean`, `--quiet`, `--force`, `-d`); err != nil {
+		return "", err
+	}
+	return git(ctx, dest, `rev-parse`, `HEAD`)
+}
 
-	// TODO: maybe implement file sync in Go
-	dest := shutil.Path(workspace, "src", pkg)
-	if !shutil.Exists(dest) {
-		shutil.MkdirParents(dest, 0755)
+const gitDefaultBranch = "refs/remotes/origin/HEAD"
+
+func gitCommitish(rev Revision) string {
+	switch {
+	case len(rev.Rev) > 0:
+		return rev.Rev
+	case len(rev.Tag) > 0:
+		return "refs/tags/" + rev.Tag
+	case len(rev.Branch) > 0:
+		return "refs/remotes/origin/" + rev.Branch
 	}
+	return gitDefaultBranch
+}
+
+// git runs a git command in a repository and returns its trimmed output.
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	return vcs(ctx, `git`, dir, args...)
+}
 
-	args := []string{`-rum`, `--exclude`, `.*`, `--delete`, src + "/", dest}
-	output, err := shutil.Cmd(`rsync`, args...).Try()
+// vcs runs a version control command in a directory and returns its trimmed
+// output, or an error including the output if the command failed.
+func vcs(ctx context.Context, tool string, dir string, args ...string) (string, error) {
+	cmd := shutil.CmdContext(ctx, tool, args...)
+	cmd.Dir = dir
+	output, err := cmd.Try()
 	if err != nil {
 		tabbedOutput := strings.Join(strings.Split(output, "\n"), "\n\t")
-		return fmt.Errorf("resolver: error copying package with rsync:\n\n\t%s\n", tabbedOutput)
+		return "", fmt.Errorf("resolver: error running \"%s %s\" in \"%s\":\n\n\t%s\n", tool, args[0], dir, tabbedOutput)
 	}
+	return strings.TrimSpace(output), nil
+}
 
-	return nil
+func pathResolver(ctx context.Context, src string, rev Revision, pkg string, workspace string) (string, error) {
+	defer debug.TimedFunction(time.Now(), "pathResolver("+src+")")
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	exclude, err := packageExcludes(src)
+	if err != nil {
+		return "", fmt.Errorf("resolver: error reading the package's config:\n\n\t%s\n", err)
+	}
+	changes, err := copyPackage(src, pkg, workspace, exclude)
+	if err != nil {
+		return "", err
+	}
+
+	// NOTE: The revision of a "path" dependency can't be pinned, but the
+	//       content hash is still recorded to show when it has changed.
+	revision, err := hashTree(shutil.Path(workspace, "src", pkg))
+	if err == nil && len(changes) == 0 {
+		return revision, AlreadyResolved
+	}
+	return revision, err
+}
+
+// copyPackage copies a package into the workspace, except for excluded files,
+// and returns the paths which were changed.  Files which are newer in the
+// workspace (eg. because they were changed by "bottle exec") aren't replaced.
+func copyPackage(src string, pkg string, workspace string, exclude *excludeList) ([]filesync.Change, error) {
+	dest := shutil.Path(workspace, "src", pkg)
+	changes, err := filesync.Sync(src, dest, filesync.Options{Exclude: exclude.Exclude, Delete: true, Update: true})
+	if err != nil {
+		return nil, fmt.Errorf("resolver: error copying package:\n\n\t%s\n", err)
+	}
+	return changes, nil
+}
+
+func isHidden(path string, isDir bool) bool {
+	return strings.HasPrefix(filepath.Base(path), ".")
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+
+	"bottle/shutil"
+)
+
+func TestGoGetOffline(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "bottle-go-get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("BOTTLE_CACHE", os.Getenv("BOTTLE_CACHE"))
+	os.Setenv("BOTTLE_CACHE", shutil.Path(dir, "cache"))
+	defer func(wasOffline bool) { offline = wasOffline }(offline)
+
+	// Make a repository for the package, and mirror it in the cache
+	repo := shutil.Path(dir, "lib")
+	shutil.MkdirParents(repo, 0755)
+	ioutil.WriteFile(shutil.Path(repo, "lib.go"), []byte("package lib\n"), 0644)
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "lib.go"},
+		{"-c", "user.name=bottle", "-c", "user.email=bottle@localhost", "commit", "--quiet", "-m", "lib"},
+	} {
+		if output, err := shutil.Cmd("git", append([]string{"-C", repo}, args...)...).Try(); err != nil {
+			t.Fatalf("git %v: %s", args, output)
+		}
+	}
+	head, err := git(context.Background(), repo, `rev-parse`, `HEAD`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	_, unlock, err := gitMirror(ctx, repo, Revision{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	// NOTE: Without the go-import meta, the repository of the package is unknown
+	offline = true
+	workspace := shutil.Path(dir, "workspace")
+	if _, err := goGetResolver(ctx, "example.com/lib", Revision{}, "example.com/lib", workspace); err == nil {
+		t.Fatalf(`goGetResolver => nil error; want an OfflineError before the meta is recorded`)
+	} else if _, ok := err.(*OfflineError); !ok {
+		t.Fatalf(`goGetResolver => %s; want an OfflineError`, err)
+	}
+
+	saveGoGetMeta("example.com/lib", &goImportMeta{prefix: "example.com/lib", vcs: "git", repo: repo})
+	revision, err := goGetResolver(ctx, "example.com/lib", Revision{}, "example.com/lib", workspace)
+	if err != nil {
+		t.Fatalf(`goGetResolver => %s; want the package cloned from the cache`, err)
+	}
+	if revision != head {
+		t.Errorf(`goGetResolver => "%s"; want "%s"`, revision, head)
+	}
+	if !shutil.IsRegularFile(shutil.Path(workspace, "src", "example.com", "lib", "lib.go")) {
+		t.Errorf(`goGetResolver didn't clone the package into the workspace`)
+	}
+}
@@ -0,0 +1,360 @@
+// Package semver parses semantic versions (see https://semver.org) and
+// checks them against version constraints, such as "^1.2" or ">=1.0, <1.4".
+//
+// Constraints follow the same rules as Cargo:
+//
+//	^1.2.3  :=  >=1.2.3, <2.0.0       ~1.2.3  :=  >=1.2.3, <1.3.0
+//	^0.2.3  :=  >=0.2.3, <0.3.0       ~1.2    :=  >=1.2.0, <1.3.0
+//	^0.0.3  :=  >=0.0.3, <0.0.4       1.2.*   :=  >=1.2.0, <1.3.0
+//	1.2     :=  ^1.2                  =1.2    :=  >=1.2.0, <1.3.0
+//
+// Comparators separated by commas must all match, and alternatives can be
+// separated with "||".  Pre-release versions only match a constraint if one
+// of its comparators has a pre-release with the same major, minor and patch.
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Version struct {
+	Major, Minor, Patch int
+	Prerelease          string
+	Build               string
+}
+
+// Parse reads a version of the form "1.2.3-pre+build".  A leading "v", as is
+// common in git tags, is ignored.
+func Parse(s string) (Version, error) {
+	v, parts, err := parsePartial(s)
+	if err != nil {
+		return v, err
+	}
+	if parts != 3 {
+		return v, fmt.Errorf(`semver: "%s" is not a complete version`, s)
+	}
+	return v, nil
+}
+
+// parsePartial reads a version which may be missing its minor and patch
+// numbers, and returns the number of parts which were present.
+func parsePartial(s string) (Version, int, error) {
+	var v Version
+	str := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(str, '+'); i >= 0 {
+		str, v.Build = str[:i], str[i+1:]
+	}
+	if i := strings.IndexByte(str, '-'); i >= 0 {
+		str, v.Prerelease = str[:i], str[i+1:]
+		if len(v.Prerelease) == 0 {
+			return v, 0, fmt.Errorf(`semver: "%s" has an empty pre-release`, s)
+		}
+	}
+
+	fields := strings.Split(str, ".")
+	if len(fields) > 3 {
+		return v, 0, fmt.Errorf(`semver: "%s" has too many parts`, s)
+	}
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 || (len(field) > 1 && field[0] == '0') {
+			return v, 0, fmt.Errorf(`semver: "%s" is not a valid version`, s)
+		}
+		*numbers[i] = n
+	}
+	if len(fields) < 3 && (len(v.Prerelease) > 0 || len(v.Build) > 0) {
+		return v, 0, fmt.Errorf(`semver: "%s" is not a complete version`, s)
+	}
+	return v, len(fields), nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + v.Prerelease
+	}
+	if len(v.Build) > 0 {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare returns -1, 0, or 1 if "v" is less than, equal to, or greater than
+// "other".  Build metadata is ignored.
+func (v Version) Compare(other Version) int {
+	if c := compareInt(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+	return comparePrerelease(v.Prerelease, other.Prerelease)
+}
+
+func (v Version) Less(other Version) bool {
+	return v.Compare(other) < 0
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case len(a) == 0:
+		return 1 // a release is greater than any of its pre-releases
+	case len(b) == 0:
+		return -1
+	}
+
+	aIds, bIds := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aIds) && i < len(bIds); i++ {
+		aNum, aErr := strconv.Atoi(aIds[i])
+		bNum, bErr := strconv.Atoi(bIds[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if c := compareInt(aNum, bNum); c != 0 {
+				return c
+			}
+		case aErr == nil:
+			return -1 // numeric identifiers are lower than alphanumeric ones
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(aIds[i], bIds[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInt(len(aIds), len(bIds))
+}
+
+type Constraint struct {
+	text         string
+	alternatives [][]comparator
+}
+
+type comparator struct {
+	op      string // one of "=", "!=", "<", "<=", ">", ">="
+	version Version
+}
+
+// ParseConstraint reads a version constraint, such as "^1.2" or ">=1.0, <1.4".
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{text: strings.TrimSpace(s)}
+	for _, alternative := range strings.Split(s, "||") {
+		var comparators []comparator
+		for _, term := range strings.Split(alternative, ",") {
+			expanded, err := parseTerm(strings.TrimSpace(term))
+			if err != nil {
+				return nil, err
+			}
+			comparators = append(comparators, expanded...)
+		}
+		c.alternatives = append(c.alternatives, comparators)
+	}
+	return c, nil
+}
+
+func (c *Constraint) String() string {
+	return c.text
+}
+
+// Check returns whether a version satisfies the constraint.
+func (c *Constraint) Check(v Version) bool {
+	for _, comparators := range c.alternatives {
+		if matchesAll(comparators, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAll(comparators []comparator, v Version) bool {
+	allowPrerelease := len(v.Prerelease) == 0
+	for _, cmp := range comparators {
+		if !cmp.matches(v) {
+			return false
+		}
+		if len(cmp.version.Prerelease) > 0 &&
+			cmp.version.Major == v.Major && cmp.version.Minor == v.Minor && cmp.version.Patch == v.Patch {
+			allowPrerelease = true
+		}
+	}
+	return allowPrerelease
+}
+
+func (cmp comparator) matches(v Version) bool {
+	c := v.Compare(cmp.version)
+	switch cmp.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// parseTerm expands a single term of a constraint into simple comparators.
+func parseTerm(term string) ([]comparator, error) {
+	if len(term) == 0 {
+		return nil, fmt.Errorf(`semver: empty term in version constraint`)
+	}
+	if term == "*" || term == "x" || term == "X" {
+		return []comparator{{op: ">=", version: Version{}}}, nil
+	}
+
+	op := ""
+	for _, prefix := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, prefix) {
+			op = prefix
+			term = strings.TrimSpace(term[len(prefix):])
+			break
+		}
+	}
+
+	// Trim wildcards, treating them like a partial version
+	wildcard := false
+	for _, suffix := range []string{".*", ".x", ".X"} {
+		for strings.HasSuffix(term, suffix) {
+			term = strings.TrimSuffix(term, suffix)
+			wildcard = true
+		}
+	}
+	if wildcard && len(op) > 0 && op != "=" {
+		return nil, fmt.Errorf(`semver: wildcard can't be used with "%s"`, op)
+	}
+
+	v, parts, err := parsePartial(term)
+	if err != nil {
+		return nil, err
+	}
+	if wildcard {
+		op = "="
+	}
+
+	lower := comparator{op: ">=", version: v}
+	switch op {
+	case "", "^":
+		// Allow changes which don't modify the left-most non-zero part
+		switch {
+		case v.Major > 0 || parts == 1:
+			return []comparator{lower, {op: "<", version: Version{Major: v.Major + 1}}}, nil
+		case v.Minor > 0 || parts == 2:
+			return []comparator{lower, {op: "<", version: Version{Minor: v.Minor + 1}}}, nil
+		default:
+			return []comparator{lower, {op: "<", version: Version{Patch: v.Patch + 1}}}, nil
+		}
+	case "~":
+		if parts == 1 {
+			return []comparator{lower, {op: "<", version: Version{Major: v.Major + 1}}}, nil
+		}
+		return []comparator{lower, {op: "<", version: Version{Major: v.Major, Minor: v.Minor + 1}}}, nil
+	case "=":
+		switch parts {
+		case 1:
+			return []comparator{lower, {op: "<", version: Version{Major: v.Major + 1}}}, nil
+		case 2:
+			return []comparator{lower, {op: "<", version: Version{Major: v.Major, Minor: v.Minor + 1}}}, nil
+		}
+		return []comparator{{op: "=", version: v}}, nil
+	case ">":
+		// A partial version excludes everything it would match (eg. ">1.2" means ">=1.3.0")
+		switch parts {
+		case 1:
+			return []comparator{{op: ">=", version: Version{Major: v.Major + 1}}}, nil
+		case 2:
+			return []comparator{{op: ">=", version: Version{Major: v.Major, Minor: v.Minor + 1}}}, nil
+		}
+		return []comparator{{op: ">", version: v}}, nil
+	case "<=":
+		switch parts {
+		case 1:
+			return []comparator{{op: "<", version: Version{Major: v.Major + 1}}}, nil
+		case 2:
+			return []comparator{{op: "<", version: Version{Major: v.Major, Minor: v.Minor + 1}}}, nil
+		}
+		return []comparator{{op: "<=", version: v}}, nil
+	}
+	return []comparator{{op: op, version: v}}, nil
+}
+
+// Highest returns the index of the highest version that satisfies the
+// constraint, or -1 if none of the versions satisfy it.
+func (c *Constraint) Highest(versions []Version) int {
+	best := -1
+	for i, v := range versions {
+		if c.Check(v) && (best < 0 || versions[best].Less(v)) {
+			best = i
+		}
+	}
+	return best
+}
+
+// Intersects returns whether a version may satisfy both constraints.  It is
+// only false if the constraints are disjoint (eg. "^1.2" and ">=2.0"); the
+// rules for "!=" and pre-release versions are ignored, so it may be true for
+// constraints which no version satisfies.
+func (c *Constraint) Intersects(other *Constraint) bool {
+	for _, a := range c.alternatives {
+		for _, b := range other.alternatives {
+			if overlaps(append(append([]comparator(nil), a...), b...)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// overlaps returns whether the range of versions between the comparators'
+// tightest lower and upper bounds is not empty.
+func overlaps(comparators []comparator) bool {
+	var lower, upper *comparator
+	for i := range comparators {
+		cmp := &comparators[i]
+		switch cmp.op {
+		case "=":
+			for _, other := range comparators {
+				if !other.matches(cmp.version) {
+					return false
+				}
+			}
+			return true
+		case ">", ">=":
+			if lower == nil || lower.version.Less(cmp.version) || (lower.version == cmp.version && cmp.op == ">") {
+				lower = cmp
+			}
+		case "<", "<=":
+			if upper == nil || cmp.version.Less(upper.version) || (upper.version == cmp.version && cmp.op == "<") {
+				upper = cmp
+			}
+		}
+	}
+	if lower == nil || upper == nil {
+		return true
+	}
+	order := lower.version.Compare(upper.version)
+	return order < 0 || (order == 0 && lower.op == ">=" && upper.op == "<=")
+}
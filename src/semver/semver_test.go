@@ -0,0 +1,138 @@
+package semver
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for _, v := range []struct {
+		input  string
+		expect Version
+	}{
+		{"1.2.3", Version{Major: 1, Minor: 2, Patch: 3}},
+		{"v0.10.0", Version{Minor: 10}},
+		{"1.0.0-rc.1", Version{Major: 1, Prerelease: "rc.1"}},
+		{"1.0.0-beta+exp.sha.5114f85", Version{Major: 1, Prerelease: "beta", Build: "exp.sha.5114f85"}},
+	} {
+		actual, err := Parse(v.input)
+		if err != nil {
+			t.Errorf(`Parse(%q) => unexpected error %v`, v.input, err)
+		} else if actual != v.expect {
+			t.Errorf(`Parse(%q) => %#v; want %#v`, v.input, actual, v.expect)
+		}
+	}
+
+	for _, input := range []string{"", "1.2", "1.2.3.4", "01.2.3", "1.x.3", "1.2.3-", "release-1"} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf(`Parse(%q) => expected an error`, input)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ordered := []string{
+		"0.9.9", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.2.0", "1.10.0", "2.0.0",
+	}
+	for i := 1; i < len(ordered); i++ {
+		a, b := mustParse(t, ordered[i-1]), mustParse(t, ordered[i])
+		if a.Compare(b) != -1 || b.Compare(a) != 1 {
+			t.Errorf(`expected %s < %s`, a, b)
+		}
+	}
+	if mustParse(t, "1.0.0+a").Compare(mustParse(t, "1.0.0+b")) != 0 {
+		t.Errorf(`expected build metadata to be ignored`)
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	for _, v := range []struct {
+		constraint string
+		matches    []string
+		rejects    []string
+	}{
+		{"^1.2", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0", "1.3.0-rc.1"}},
+		{"^1.2.3", []string{"1.2.3", "1.4.0"}, []string{"1.2.2", "2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0", "0.2.2"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"1.2", []string{"1.2.0", "1.5.0"}, []string{"2.0.0"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"1.2.*", []string{"1.2.0", "1.2.7"}, []string{"1.3.0"}},
+		{"*", []string{"0.0.1", "3.0.0"}, []string{"1.0.0-alpha"}},
+		{"=1.2.3", []string{"1.2.3"}, []string{"1.2.4"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{"<=1.2", []string{"1.2.9"}, []string{"1.3.0"}},
+		{">=1.0, <1.4", []string{"1.0.0", "1.3.9"}, []string{"1.4.0", "0.9.0"}},
+		{"^1 || ^3", []string{"1.1.0", "3.0.0"}, []string{"2.0.0"}},
+		{">=1.0.0-rc.1", []string{"1.0.0-rc.2", "1.0.0"}, []string{"1.1.0-rc.1"}},
+	} {
+		c, err := ParseConstraint(v.constraint)
+		if err != nil {
+			t.Errorf(`ParseConstraint(%q) => unexpected error %v`, v.constraint, err)
+			continue
+		}
+		for _, version := range v.matches {
+			if !c.Check(mustParse(t, version)) {
+				t.Errorf(`%q should match %s`, v.constraint, version)
+			}
+		}
+		for _, version := range v.rejects {
+			if c.Check(mustParse(t, version)) {
+				t.Errorf(`%q should not match %s`, v.constraint, version)
+			}
+		}
+	}
+
+	for _, input := range []string{"", "^", ">=1.0,", "~1.2.x", "^a.b"} {
+		if _, err := ParseConstraint(input); err == nil {
+			t.Errorf(`ParseConstraint(%q) => expected an error`, input)
+		}
+	}
+}
+
+func TestHighest(t *testing.T) {
+	c, _ := ParseConstraint("^1.2")
+	versions := []Version{mustParse(t, "1.2.0"), mustParse(t, "2.0.0"), mustParse(t, "1.10.1"), mustParse(t, "1.3.0")}
+	if i := c.Highest(versions); i != 2 {
+		t.Errorf(`Highest => %d; want 2`, i)
+	}
+	c, _ = ParseConstraint("^3")
+	if i := c.Highest(versions); i != -1 {
+		t.Errorf(`Highest => %d; want -1`, i)
+	}
+}
+
+func mustParse(t *testing.T, s string) Version {
+	v, err := Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestIntersects(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"^1.2", "^1.4", true},
+		{"^1.2", ">=2.0", false},
+		{"^1", "^2", false},
+		{">=1.0, <1.1 || ^2", "^2.3", true},
+		{">=1.0, <1.1 || ^2", "^1.2", false},
+		{"<1.2.0", ">=1.2.0", false},
+		{"<=1.2.0", ">=1.2.0", true},
+		{"=1.2.3", "~1.2", true},
+		{"=1.2.3", ">1.2.3", false},
+		{"*", "^0.0.3", true},
+	}
+	for _, test := range tests {
+		a, _ := ParseConstraint(test.a)
+		b, _ := ParseConstraint(test.b)
+		if got := a.Intersects(b); got != test.want {
+			t.Errorf(`"%s".Intersects("%s") => %v; want %v`, test.a, test.b, got, test.want)
+		}
+		if got := b.Intersects(a); got != test.want {
+			t.Errorf(`"%s".Intersects("%s") => %v; want %v`, test.b, test.a, got, test.want)
+		}
+	}
+}
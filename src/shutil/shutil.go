@@ -2,6 +2,7 @@ package shutil
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -33,6 +34,14 @@ func Cmd(path string, args ...string) Command {
 	return Command{Cmd: cmd}
 }
 
+// CmdContext is like Cmd, but the command is killed if the context is done
+// before it exits.
+func CmdContext(ctx context.Context, path string, args ...string) Command {
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = os.Environ()
+	return Command{Cmd: cmd}
+}
+
 func (cmd Command) Run() string {
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
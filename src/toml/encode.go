@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
 	"strconv"
 	"time"
 
@@ -17,7 +18,8 @@ const (
 
 // Marshal returns the TOML encoding of v.
 //
-// Struct values encode as TOML. Each exported struct field becomes a field of
+// Struct values encode as TOML, and maps with string keys encode as tables
+// (in the order of their keys). Each exported struct field becomes a field of
 // the TOML structure unless
 //   - the field's tag is "-", or
 //   - the field is empty and its tag specifies the "omitempty" option.
@@ -87,7 +89,7 @@ func marshal(buf []byte, prefix string, rv reflect.Value, inArray, arrayTable bo
 		fv := rv.Field(i)
 		switch rest {
 		case tagOmitempty:
-			if fv.Interface() == reflect.Zero(ft.Type).Interface() {
+			if isEmptyValue(fv) {
 				continue
 			}
 		}
@@ -150,6 +152,38 @@ func encodeValue(buf []byte, prefix, name string, fv reflect.Value, inArray, arr
 	case reflect.Struct:
 		name := tableName(prefix, name)
 		return marshal(append(append(append(buf, '['), name...), ']', '\n'), name, fv, inArray, arrayTable)
+	case reflect.Map:
+		if inArray {
+			return nil, fmt.Errorf("toml: marshal: unsupported map in array")
+		}
+		if fv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("toml: marshal: unsupported map key type %v", fv.Type().Key().Kind())
+		}
+		keys := fv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+		// Values which are structs are written as subtables of the map's
+		// table, and other values are written as keys in its table
+		name := tableName(prefix, name)
+		buf = append(append(append(buf, '['), name...), ']', '\n')
+		et := fv.Type().Elem()
+		for et.Kind() == reflect.Ptr {
+			et = et.Elem()
+		}
+		var err error
+		for _, key := range keys {
+			value := reflect.Indirect(fv.MapIndex(key))
+			if et.Kind() == reflect.Struct {
+				subtable := tableName(name, encodeKey(key.String()))
+				buf, err = marshal(append(append(append(buf, '['), subtable...), ']', '\n'), subtable, value, false, false)
+			} else {
+				buf, err = encodeValue(buf, name, encodeKey(key.String()), value, false, false)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
 	case reflect.Interface:
 		var err error
 		if buf, err = encodeInterface(appendKey(buf, name, inArray, arrayTable), fv.Interface()); err != nil {
@@ -160,6 +194,33 @@ func encodeValue(buf []byte, prefix, name string, fv reflect.Value, inArray, arr
 	return nil, fmt.Errorf("toml: marshal: unsupported type %v", fv.Kind())
 }
 
+// isEmptyValue returns whether a value is empty for the "omitempty" option.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	if v.Type().Comparable() {
+		return v.Interface() == reflect.Zero(v.Type()).Interface()
+	}
+	return false
+}
+
+// encodeKey quotes a key unless it is a bare key.
+func encodeKey(key string) string {
+	if len(key) == 0 {
+		return `""`
+	}
+	for _, r := range key {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return strconv.Quote(key)
+		}
+	}
+	return key
+}
+
 func appendKey(buf []byte, key string, inArray, arrayTable bool) []byte {
 	if !inArray {
 		return append(append(buf, key...), '=')
@@ -294,3 +294,76 @@ name="plantain"
 		}
 	}
 }
+
+func TestMarshalMap(t *testing.T) {
+	type dependency struct {
+		Path string `toml:",omitempty"`
+		Git  string `toml:",omitempty"`
+	}
+	for _, v := range []struct {
+		v      interface{}
+		expect string
+	}{
+		{struct{ Env map[string]string }{map[string]string{"b": "2", "a": "1"}}, "[env]\na=\"1\"\nb=\"2\"\n"},
+		{struct{ Env map[string]int }{map[string]int{"a.b": 1, "": 2}}, "[env]\n\"\"=2\n\"a.b\"=1\n"},
+		{struct{ Env map[string]int }{}, "[env]\n"},
+		{struct {
+			Env map[string]int `toml:",omitempty"`
+		}{}, ""},
+		{struct {
+			Names []string `toml:",omitempty"`
+		}{}, ""},
+		{struct {
+			Deps map[string]dependency
+		}{map[string]dependency{
+			"github.com/a/b": {Git: "https://github.com/a/b.git"},
+			"example.com/c":  {Path: "../c"},
+			"golang.org/x/d": {},
+		}}, "[deps]\n[deps.\"example.com/c\"]\npath=\"../c\"\n[deps.\"github.com/a/b\"]\ngit=\"https://github.com/a/b.git\"\n[deps.\"golang.org/x/d\"]\n"},
+	} {
+		b, err := Marshal(v.v)
+		if err != nil {
+			t.Errorf(`Marshal(%#v) => unexpected error %v`, v.v, err)
+			continue
+		}
+		if string(b) != v.expect {
+			t.Errorf(`Marshal(%#v) => %#v; want %#v`, v.v, string(b), v.expect)
+		}
+	}
+
+	if _, err := Marshal(struct{ Env map[int]string }{map[int]string{1: "a"}}); err == nil {
+		t.Errorf(`Marshal(map[int]string) => no error; want an unsupported type error`)
+	}
+}
+
+func TestMarshalMapRoundTrip(t *testing.T) {
+	type dependency struct {
+		Path string `toml:",omitempty"`
+		Git  string `toml:",omitempty"`
+	}
+	type config struct {
+		Package struct {
+			Name string
+		}
+		Dependencies map[string]dependency
+	}
+	var expect config
+	expect.Package.Name = "app"
+	expect.Dependencies = map[string]dependency{
+		"github.com/a/b": {Git: "https://github.com/a/b.git"},
+		"example.com/c":  {Path: "../c"},
+		"golang.org/x/d": {},
+	}
+
+	b, err := Marshal(expect)
+	if err != nil {
+		t.Fatalf(`Marshal => unexpected error %v`, err)
+	}
+	var actual config
+	if err := Unmarshal(b, &actual); err != nil {
+		t.Fatalf(`Unmarshal(%q) => unexpected error %v`, b, err)
+	}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`Unmarshal(Marshal(%#v)) => %#v`, expect, actual)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"bottle/shutil"
+	"bottle/toml"
+)
+
+// UserConfig contains the user's settings, which apply to every project.
+type UserConfig struct {
+	Cache     string            // directory of the shared dependency cache
+	Jobs      int               // how many dependencies to resolve at the same time
+	Resolvers map[string]string // executables which resolve custom protocols
+}
+
+var userConfig UserConfig
+
+// userConfigPath returns the location of the user's config file, which is
+// "$BOTTLE_CONFIG" if it is set, or else "bottle/config.toml" in the user's
+// XDG config directory.
+func userConfigPath() string {
+	env := shutil.Env()
+	switch {
+	case len(env["BOTTLE_CONFIG"]) > 0:
+		return env["BOTTLE_CONFIG"]
+	case len(env["XDG_CONFIG_HOME"]) > 0:
+		return shutil.Path(env["XDG_CONFIG_HOME"], "bottle", "config.toml")
+	case len(env["HOME"]) > 0:
+		return shutil.Path(env["HOME"], ".config", "bottle", "config.toml")
+	}
+	return ""
+}
+
+func loadUserConfig() error {
+	filename := userConfigPath()
+	if len(filename) == 0 || !shutil.IsRegularFile(filename) {
+		return nil
+	}
+
+	err := toml.Unmarshal(shutil.Binread(filename), &userConfig)
+	if err != nil {
+		return fmt.Errorf("Failed to read user config \"%s\":\n\n\t%s\n", filename, err)
+	}
+	return nil
+}
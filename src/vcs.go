@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"bottle/debug"
+	"bottle/shutil"
+)
+
+// These resolvers work the same way as the gitResolver: the repository is
+// cloned once into the workspace, and is only updated when a different
+// revision is requested (or the dependency is being refreshed).
+
+func hgResolver(ctx context.Context, src string, rev Revision, pkg string, workspace string) (string, error) {
+	defer debug.TimedFunction(time.Now(), "hgResolver("+src+")")
+
+	dest := shutil.Path(workspace, "src", pkg)
+	defer lockDestination(dest)()
+
+	// Don't re-clone the repository if it already exists
+	if shutil.Exists(dest) {
+		if !shutil.Exists(shutil.Path(dest, ".hg")) {
+			return "", fmt.Errorf("While resolving %s, directory \"%s\" exists but is not a Mercurial repository", pkg, dest)
+		}
+
+		head, err := hgRevision(ctx, dest, ".")
+		if err != nil {
+			return "", err
+		}
+		if rev == (Revision{}) {
+			return head, AlreadyResolved
+		}
+
+		// Check to see if we are on the correct revision, but always pull
+		// branches because the branch's head is probably out of date
+		if len(rev.Branch) == 0 && !rev.Refresh {
+			node, err := hgRevision(ctx, dest, hgRevset(rev))
+			if err == nil && node == head {
+				return head, AlreadyResolved
+			}
+		}
+
+		// If not, do a "clean" update to the correct revision
+		if !offline {
+			if _, err := vcs(ctx, `hg`, dest, `pull`, `--quiet`); err != nil {
+				return "", err
+			}
+		}
+		node, err := hgUpdate(ctx, dest, rev)
+		if err != nil && offline {
+			return "", &OfflineError{Package: pkg, Reason: describeRevision(rev) + " is not in the workspace or cache"}
+		}
+		if err == nil && node == head {
+			return head, AlreadyResolved
+		}
+		return node, err
+	}
+
+	// Actually clone the repository
+	if offline {
+		return "", &OfflineError{Package: pkg, Reason: describeRevision(rev) + " is not in the workspace or cache"}
+	}
+	node, err := hgClone(ctx, src, rev, dest)
+	removeIncomplete(dest, err)
+	return node, err
+}
+
+func hgClone(ctx context.Context, src string, rev Revision, dest string) (string, error) {
+	output, err := shutil.CmdContext(ctx, `hg`, `clone`, `--quiet`, `--noupdate`, src, dest).Try()
+	if err != nil {
+		tabbedOutput := strings.Join(strings.Split(output, "\n"), "\n\t")
+		return "", fmt.Errorf("resolver: error cloning repository with hg:\n\n\t%s\n", tabbedOutput)
+	}
+	return hgUpdate(ctx, dest, rev)
+}
+
+// hgUpdate does a clean update to the revision in the repository at "dest",
+// discarding any local changes, and returns the new changeset id.
+func hgUpdate(ctx context.Context, dest string, rev Revision) (string, error) {
+	if _, err := vcs(ctx, `hg`, dest, `update`, `--quiet`, `--clean`, `--rev`, hgRevset(rev)); err != nil {
+		return "", err
+	}
+	if _, err := vcs(ctx, `hg`, dest, `--config`, `extensions.purge=`, `purge`); err != nil {
+		return "", err
+	}
+	return hgRevision(ctx, dest, ".")
+}
+
+func hgRevision(ctx context.Context, dest string, revset string) (string, error) {
+	return vcs(ctx, `hg`, dest, `log`, `--rev`, revset, `--template`, `{node}`)
+}
+
+func hgRevset(rev Revision) string {
+	switch {
+	case len(rev.Rev) > 0:
+		return rev.Rev
+	case len(rev.Tag) > 0:
+		return rev.Tag
+	case len(rev.Branch) > 0:
+		return rev.Branch
+	}
+	return "default"
+}
+
+func svnResolver(ctx context.Context, src string, rev Revision, pkg string, workspace string) (string, error) {
+	defer debug.TimedFunction(time.Now(), "svnResolver("+src+")")
+
+	// NOTE: Subversion tags and branches are just directories, so the URL of
+	//       the tag or branch should be used as the dependency's source.
+	if len(rev.Tag) > 0 || len(rev.Branch) > 0 {
+		return "", fmt.Errorf(`resolver: while resolving %s, Subversion dependencies can only select a "rev"`, pkg)
+	}
+
+	dest := shutil.Path(workspace, "src", pkg)
+	defer lockDestination(dest)()
+
+	// Don't re-checkout the repository if it already exists
+	if shutil.Exists(dest) {
+		if !shutil.Exists(shutil.Path(dest, ".svn")) {
+			return "", fmt.Errorf("While resolving %s, directory \"%s\" exists but is not a Subversion working copy", pkg, dest)
+		}
+
+		head, err := svnRevision(ctx, dest)
+		if err != nil {
+			return "", err
+		}
+		if rev == (Revision{}) || (rev.Rev == head && !rev.Refresh) {
+			return head, AlreadyResolved
+		}
+
+		// If not, do a "clean" update to the correct revision
+		if offline {
+			return "", &OfflineError{Package: pkg, Reason: describeRevision(rev) + " is not in the workspace or cache"}
+		}
+		revision, err := svnUpdate(ctx, dest, rev)
+		if err == nil && revision == head {
+			return head, AlreadyResolved
+		}
+		return revision, err
+	}
+
+	// Actually checkout the repository
+	if offline {
+		return "", &OfflineError{Package: pkg, Reason: describeRevision(rev) + " is not in the workspace or cache"}
+	}
+	revision, err := svnCheckout(ctx, src, rev, dest)
+	removeIncomplete(dest, err)
+	return revision, err
+}
+
+func svnCheckout(ctx context.Context, src string, rev Revision, dest string) (string, error) {
+	output, err := shutil.CmdContext(ctx, `svn`, `checkout`, `--quiet`, `--revision`, svnRevisionArg(rev), src, dest).Try()
+	if err != nil {
+		tabbedOutput := strings.Join(strings.Split(output, "\n"), "\n\t")
+		return "", fmt.Errorf("resolver: error checking out repository with svn:\n\n\t%s\n", tabbedOutput)
+	}
+	return svnRevision(ctx, dest)
+}
+
+// svnUpdate does a clean update to the revision in the working copy at
+// "dest", discarding any local changes, and returns the new revision number.
+func svnUpdate(ctx context.Context, dest string, rev Revision) (string, error) {
+	if _, err := vcs(ctx, `svn`, dest, `revert`, `--quiet`, `--recursive`, `.`); err != nil {
+		return "", err
+	}
+	if _, err := vcs(ctx, `svn`, dest, `cleanup`, `--remove-unversioned`); err != nil {
+		return "", err
+	}
+	if _, err := vcs(ctx, `svn`, dest, `update`, `--quiet`, `--revision`, svnRevisionArg(rev)); err != nil {
+		return "", err
+	}
+	return svnRevision(ctx, dest)
+}
+
+func svnRevision(ctx context.Context, dest string) (string, error) {
+	return vcs(ctx, `svn`, dest, `info`, `--show-item`, `revision`)
+}
+
+func svnRevisionArg(rev Revision) string {
+	if len(rev.Rev) > 0 {
+		return rev.Rev
+	}
+	return "HEAD"
+}
+
+func bzrResolver(ctx context.Context, src string, rev Revision, pkg string, workspace string) (string, error) {
+	defer debug.TimedFunction(time.Now(), "bzrResolver("+src+")")
+
+	// NOTE: Bazaar branches are separate directories, so the URL of the
+	//       branch should be used as the dependency's source.
+	if len(rev.Branch) > 0 {
+		return "", fmt.Errorf(`resolver: while resolving %s, Bazaar dependencies can't select a "branch"`, pkg)
+	}
+
+	dest := shutil.Path(workspace, "src", pkg)
+	defer lockDestination(dest)()
+
+	// Don't re-branch the repository if it already exists
+	if shutil.Exists(dest) {
+		if !shutil.Exists(shutil.Path(dest, ".bzr")) {
+			return "", fmt.Errorf("While resolving %s, directory \"%s\" exists but is not a Bazaar branch", pkg, dest)
+		}
+
+		head, err := bzrRevision(ctx, dest, "")
+		if err != nil {
+			return "", err
+		}
+		if rev == (Revision{}) {
+			return head, AlreadyResolved
+		}
+
+		// Check to see if we are on the correct revision
+		if !rev.Refresh {
+			revid, err := bzrRevision(ctx, dest, bzrRevisionSpec(rev))
+			if err == nil && revid == head {
+				return head, AlreadyResolved
+			}
+		}
+
+		// If not, do a "clean" pull of the correct revision
+		if offline {
+			return "", &OfflineError{Package: pkg, Reason: describeRevision(rev) + " is not in the workspace or cache"}
+		}
+		if _, err := vcs(ctx, `bzr`, dest, `revert`, `--quiet`); err != nil {
+			return "", err
+		}
+		if _, err := vcs(ctx, `bzr`, dest, `clean-tree`, `--quiet`, `--unknown`, `--force`); err != nil {
+			return "", err
+		}
+		args := []string{`pull`, `--quiet`, `--overwrite`}
+		if spec := bzrRevisionSpec(rev); len(spec) > 0 {
+			args = append(args, `--revision`, spec)
+		}
+		if _, err := vcs(ctx, `bzr`, dest, args...); err != nil {
+			return "", err
+		}
+		revid, err := bzrRevision(ctx, dest, "")
+		if err == nil && revid == head {
+			return head, AlreadyResolved
+		}
+		return revid, err
+	}
+
+	// Actually branch the repository
+	if offline {
+		return "", &OfflineError{Package: pkg, Reason: describeRevision(rev) + " is not in the workspace or cache"}
+	}
+	revid, err := bzrBranch(ctx, src, rev, dest)
+	removeIncomplete(dest, err)
+	return revid, err
+}
+
+func bzrBranch(ctx context.Context, src string, rev Revision, dest string) (string, error) {
+	args := []string{`branch`, `--quiet`}
+	if spec := bzrRevisionSpec(rev); len(spec) > 0 {
+		args = append(args, `--revision`, spec)
+	}
+	output, err := shutil.CmdContext(ctx, `bzr`, append(args, src, dest)...).Try()
+	if err != nil {
+		tabbedOutput := strings.Join(strings.Split(output, "\n"), "\n\t")
+		return "", fmt.Errorf("resolver: error branching repository with bzr:\n\n\t%s\n", tabbedOutput)
+	}
+	return bzrRevision(ctx, dest, "")
+}
+
+// bzrRevision returns the revision id of a revision (or of the working tree,
+// if "spec" is empty).
+func bzrRevision(ctx context.Context, dest string, spec string) (string, error) {
+	args := []string{`revision-info`}
+	if len(spec) > 0 {
+		args = append(args, `--revision`, spec)
+	}
+	output, err := vcs(ctx, `bzr`, dest, args...)
+	if err != nil {
+		return "", err
+	}
+
+	// NOTE: The output is formatted as "<revno> <revid>"
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return "", fmt.Errorf(`resolver: unexpected output from "bzr revision-info" in "%s": %s`, dest, output)
+	}
+	return fields[1], nil
+}
+
+func bzrRevisionSpec(rev Revision) string {
+	switch {
+	case len(rev.Rev) > 0:
+		// NOTE: Revision ids (which are pinned in the lockfile) contain an
+		//       email address, while anything else is a revision number
+		if strings.Contains(rev.Rev, "@") && !strings.Contains(rev.Rev, ":") {
+			return "revid:" + rev.Rev
+		}
+		return rev.Rev
+	case len(rev.Tag) > 0:
+		return "tag:" + rev.Tag
+	}
+	return ""
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"bottle/debug"
+	"bottle/shutil"
+	"bottle/toml"
+)
+
+const vendorManifestName = "bottle-vendor.toml"
+
+// VendorManifest is the format of "vendor/bottle-vendor.toml", which records
+// where each of the vendored packages came from.
+type VendorManifest struct {
+	Dependency []VendoredDependency
+}
+
+type VendoredDependency struct {
+	ImportPath string
+	Protocol   string // "fallback" for packages fetched by "go get"
+	Repository string
+	Revision   string
+	Version    string `toml:",omitempty"`
+	Packages   []string
+}
+
+// Vendor copies every package imported by the project (or by its tests) from
+// the workspace into "<root>/vendor", and returns the manifest describing the
+// vendored packages.  The existing vendor directory is replaced.
+//
+// The dependencies are copied with the imports which were renamed in the
+// workspace (see rename.go), so the "as" paths in the configs of dependencies
+// work in the vendor directory.  The project's own renames are refused,
+// because only the workspace copy of the project imports the new paths.
+func (deps *DependencyTracker) Vendor() (*VendorManifest, error) {
+	defer debug.TimedFunction(time.Now(), "DependencyTracker.Vendor()")
+
+	if renames := configRenames(deps.rootConfig); len(renames) > 0 {
+		var lines []string
+		for original, renamed := range renames {
+			lines = append(lines, original+" as "+renamed)
+		}
+		sort.Strings(lines)
+		return nil, fmt.Errorf("Can't vendor dependencies which are renamed by \"%s\":\n\n\t%s\n\n"+
+			"Only the workspace copy of the project imports the new paths, so the project\n"+
+			"couldn't be built with the vendor directory.\n",
+			shutil.Path(deps.rootConfig.Project, "Bottle.toml"), strings.Join(lines, "\n\t"))
+	}
+
+	packages, err := deps.importedPackages()
+	if err != nil {
+		return nil, err
+	}
+
+	// Group the packages by the dependency which contains them
+	workspaceSrc := shutil.Path(deps.rootConfig.Workspace, "src")
+	vendored := make(map[string]*VendoredDependency)
+	for _, pkg := range packages {
+		owner, err := deps.vendoredDependency(pkg)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := vendored[owner.ImportPath]; !exists {
+			vendored[owner.ImportPath] = owner
+		}
+		vendored[owner.ImportPath].Packages = append(vendored[owner.ImportPath].Packages, pkg)
+	}
+
+	// Copy the packages into a temporary directory, so that the existing
+	// vendor directory is left alone if anything fails
+	vendorDir := shutil.Path(deps.rootConfig.Package.Root, "vendor")
+	tmpDir := shutil.Path(deps.rootConfig.Package.Root, ".vendor.tmp")
+	shutil.RmRecursive(tmpDir)
+	defer shutil.RmRecursive(tmpDir)
+
+	var manifest VendorManifest
+	for _, owner := range vendored {
+		if err := copyFiles(shutil.Path(workspaceSrc, owner.ImportPath), shutil.Path(tmpDir, owner.ImportPath), isLicenseFile); err != nil {
+			return nil, err
+		}
+		for _, pkg := range owner.Packages {
+			if err := copyFiles(shutil.Path(workspaceSrc, pkg), shutil.Path(tmpDir, pkg), nil); err != nil {
+				return nil, err
+			}
+		}
+		sort.Strings(owner.Packages)
+		manifest.Dependency = append(manifest.Dependency, *owner)
+	}
+	sort.Slice(manifest.Dependency, func(i, j int) bool {
+		return manifest.Dependency[i].ImportPath < manifest.Dependency[j].ImportPath
+	})
+
+	data, err := toml.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode vendor manifest:\n\n\t%s\n", err)
+	}
+	shutil.MkdirParents(tmpDir, 0755)
+	err = ioutil.WriteFile(shutil.Path(tmpDir, vendorManifestName), append([]byte(lockfileHeader), data...), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to write vendor manifest:\n\n\t%s\n", err)
+	}
+
+	shutil.RmRecursive(vendorDir)
+	if err := os.Rename(tmpDir, vendorDir); err != nil {
+		return nil, fmt.Errorf("Failed to replace \"%s\":\n\n\t%s\n", vendorDir, err)
+	}
+	return &manifest, nil
+}
+
+// importedPackages uses the go tool to list the non-standard packages which
+// are imported by the project, excluding the project's own packages.
+func (deps *DependencyTracker) importedPackages() ([]string, error) {
+	root := deps.rootConfig.Package.Name
+	target := shutil.Path(deps.rootConfig.Workspace, "src", root)
+
+	// NOTE: The old vendor directory is removed from the workspace, so that
+	//       the packages are found in the workspace instead
+	shutil.RmRecursive(shutil.Path(target, "vendor"))
+
+	format := `{{if not .Standard}}{{.ImportPath}}{{end}}`
+	cmd := shutil.Cmd(`go`, `list`, `-deps`, `-test`, `-f`, format, `./...`)
+	cmd.Env = append([]string{"GOPATH=" + deps.rootConfig.Workspace}, cmd.Env...)
+	cmd.Dir = target
+	output, err := cmd.Try()
+	if err != nil {
+		tabbedOutput := strings.Join(strings.Split(output, "\n"), "\n\t")
+		return nil, fmt.Errorf("Failed to list the imported packages:\n\n\t%s\n", tabbedOutput)
+	}
+
+	var packages []string
+	seen := make(map[string]bool)
+	for _, pkg := range strings.Split(strings.TrimSpace(output), "\n") {
+		// NOTE: Skip the variants of packages which are compiled for tests
+		//       (eg. "a/b [a/b.test]" and "a/b.test")
+		if len(pkg) == 0 || strings.Contains(pkg, " ") || strings.HasSuffix(pkg, ".test") {
+			continue
+		}
+		if pkg == root || strings.HasPrefix(pkg, root+"/") || seen[pkg] {
+			continue
+		}
+		seen[pkg] = true
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+	return packages, nil
+}
+
+// vendoredDependency returns the dependency which contains a package.  A
+// package fetched by "go get" belongs to the repository that contains it.
+func (deps *DependencyTracker) vendoredDependency(pkg string) (*VendoredDependency, error) {
+	var owner Dependency
+	var ownerPath string
+	for dep, importPath := range deps.canonicalPaths {
+		if _, resolved := deps.revisions[dep]; !resolved {
+			continue
+		}
+		if (pkg == importPath || strings.HasPrefix(pkg, importPath+"/")) && len(importPath) > len(ownerPath) {
+			owner, ownerPath = dep, importPath
+		}
+	}
+	if len(ownerPath) > 0 {
+		locked := deps.lockedDependency(owner)
+		return &VendoredDependency{
+			ImportPath: locked.ImportPath,
+			Protocol:   locked.Protocol,
+			Repository: locked.Repository,
+			Revision:   locked.Revision,
+			Version:    locked.Version,
+		}, nil
+	}
+
+	workspaceSrc := shutil.Path(deps.rootConfig.Workspace, "src")
+	dir := shutil.Path(workspaceSrc, pkg)
+	revision := ""
+	if root, vcs := findRepositoryRoot(dir, workspaceSrc); len(root) > 0 {
+		dir = root
+		if vcs == "git" {
+			revision, _ = git(context.Background(), root, `rev-parse`, `HEAD`)
+		}
+	}
+	if len(revision) == 0 {
+		var err error
+		if revision, err = hashTree(dir); err != nil {
+			return nil, err
+		}
+	}
+	importPath := filepath.ToSlash(shutil.Relpath(workspaceSrc, dir))
+	return &VendoredDependency{
+		ImportPath: importPath,
+		Protocol:   "fallback",
+		Repository: importPath,
+		Revision:   revision,
+	}, nil
+}
+
+// copyFiles copies the regular files in a directory (but not its
+// subdirectories or hidden files) which match the filter, if it isn't nil.
+func copyFiles(src, dest string, filter func(name string) bool) error {
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return fmt.Errorf("Failed to read package directory \"%s\":\n\n\t%s\n", src, err)
+	}
+	for _, file := range files {
+		name := file.Name()
+		if !file.Mode().IsRegular() || strings.HasPrefix(name, ".") || (filter != nil && !filter(name)) {
+			continue
+		}
+		shutil.MkdirParents(dest, 0755)
+		shutil.Cp(shutil.Path(src, name), shutil.Path(dest, name))
+	}
+	return nil
+}
+
+func isLicenseFile(name string) bool {
+	name = strings.ToUpper(name)
+	for _, prefix := range []string{"LICENSE", "LICENCE", "COPYING", "NOTICE", "UNLICENSE"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bottle/shutil"
+)
+
+// workspaceOverride is the directory used as every project's workspace.  It
+// is set by the "--workspace" option, or by setting BOTTLE_WORKSPACE in the
+// environment.
+var workspaceOverride string
+
+func workspaceFromEnv() string {
+	return shutil.Env()["BOTTLE_WORKSPACE"]
+}
+
+// workspaceDir returns the directory of a project's workspace (its GOPATH).
+// Unless it is overridden, or set by the "workspace" in the project's config,
+// it is a temporary directory named by the package and a hash of the project
+// directory, so that projects (or checkouts of a project) which have the same
+// package name don't share a workspace.  A workspace in the package's
+// directory must be hidden (eg. ".workspace") or excluded, or it would be
+// copied into itself (see checkWorkspaceDir).
+func workspaceDir(cfg *Config) string {
+	switch {
+	case len(workspaceOverride) > 0:
+		return shutil.Abspath(workspaceOverride)
+	case len(cfg.Package.Workspace) > 0:
+		return shutil.Abspath(shutil.Path(cfg.Project, cfg.Package.Workspace))
+	}
+	return defaultWorkspaceDir(cfg)
+}
+
+// checkWorkspaceDir returns an error if the project's workspace would be
+// copied into itself, because it is in the package's root directory and
+// isn't hidden or excluded.
+func checkWorkspaceDir(cfg *Config) error {
+	if !shutil.IsSubdir(cfg.Workspace, cfg.Package.Root) {
+		return nil
+	}
+	rel := filepath.ToSlash(shutil.Relpath(cfg.Package.Root, cfg.Workspace))
+	if rel != "." {
+		exclude, err := newExcludeList(cfg.Package.Root, cfg.Package.Exclude, cfg.Package.Gitignore)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(rel, "/")
+		for i := range parts {
+			if exclude.Exclude(strings.Join(parts[:i+1], "/"), true) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf(`the workspace "%s" is in the package's directory, so it would be copied into itself (use a hidden directory like ".workspace", or exclude it)`, cfg.Workspace)
+}
+
+// workspacesDir is the directory of the temporary workspaces.
+func workspacesDir() string {
+	return shutil.Path(os.TempDir(), "bottle")
+}
+
+func defaultWorkspaceDir(cfg *Config) string {
+	return shutil.Path(workspacesDir(), cfg.Package.Name+"-"+cacheKey(cfg.Project)[:12])
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bottle/filemutex"
+	"bottle/shutil"
+)
+
+// The workspace is locked so that two bottle processes in the same project
+// (eg. an editor plugin and a terminal) don't copy and clone into it at the
+// same time.  A process holds an exclusive lock while it synchronizes the
+// workspace, and then a shared lock while it uses the workspace, so another
+// process can't synchronize it until the command is done.
+//
+// Each process which holds the lock writes a file named by its PID to
+// ".bottle-lock.d" in the workspace, so that a waiting process can say what
+// it is waiting for.
+
+// lockTimeout is how long to wait for another process to release the
+// workspace.  It is set by the "--lock-timeout" option, or by setting
+// BOTTLE_LOCK_TIMEOUT in the environment.
+var lockTimeout time.Duration
+
+const defaultLockTimeout = 5 * time.Minute
+
+func lockTimeoutFromEnv() time.Duration {
+	if timeout, err := time.ParseDuration(shutil.Env()["BOTTLE_LOCK_TIMEOUT"]); err == nil {
+		return timeout
+	}
+	return defaultLockTimeout
+}
+
+type workspaceLock struct {
+	mutex     *filemutex.FileMutex
+	exclusive bool
+	holder    string // the file which names this process as a holder
+}
+
+// heldLock is the shared lock which is held after synchronizing the
+// workspace, until the command exits.
+var heldLock *workspaceLock
+
+// lockWorkspace waits until it can lock the workspace, or until the timeout,
+// and prints a message naming the processes which hold the lock while it is
+// waiting.
+func lockWorkspace(workspace string, exclusive bool) (*workspaceLock, error) {
+	deadline := time.Now().Add(lockTimeout)
+	for waiting := false; ; waiting = true {
+		lock, holders, err := tryLockWorkspace(workspace, exclusive)
+		if err != nil || lock != nil {
+			return lock, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out after %s waiting for the lock on \"%s\", which is held by %s\n", lockTimeout, workspace, holders)
+		}
+		if !waiting {
+			shutil.Stderr("Waiting for the lock on \"" + workspace + "\", which is held by " + holders + "\n")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// tryLockWorkspace locks the workspace, or returns a nil lock and the names of
+// the processes which hold the lock if it is already locked.
+func tryLockWorkspace(workspace string, exclusive bool) (*workspaceLock, string, error) {
+	filename := shutil.Path(workspace, ".bottle-lock")
+	for {
+		shutil.MkdirParents(shutil.Path(workspace, ".bottle-lock.d"), 0755)
+		mutex, err := filemutex.New(filename)
+		if err != nil {
+			return nil, "", err
+		}
+		lock := &workspaceLock{mutex: mutex, exclusive: exclusive}
+		if !lock.tryLock() {
+			mutex.Close()
+			holders := "another process"
+			if names := lockHolders(workspace); len(names) > 0 {
+				holders = strings.Join(names, ", ")
+			}
+			return nil, holders, nil
+		}
+
+		// NOTE: If the workspace was removed (eg. by "bottle clean") after the
+		//       file was opened, the lock doesn't exclude anything.
+		if !mutex.SameFile(filename) {
+			lock.Unlock()
+			continue
+		}
+
+		lockHolders(workspace) // NOTE: removes the files of processes which have exited
+		lock.holder = shutil.Path(workspace, ".bottle-lock.d", strconv.Itoa(os.Getpid()))
+		lock.writeHolder()
+		return lock, "", nil
+	}
+}
+
+func (lock *workspaceLock) tryLock() bool {
+	if lock.exclusive {
+		return lock.mutex.TryLock()
+	}
+	return lock.mutex.TryRLock()
+}
+
+// Downgrade changes an exclusive lock to a shared lock, without letting
+// another process synchronize the workspace in between.
+func (lock *workspaceLock) Downgrade() {
+	if !lock.exclusive {
+		return
+	}
+	lock.mutex.Downgrade()
+	lock.exclusive = false
+	lock.writeHolder()
+}
+
+// writeHolder names this process as a holder of the lock.
+func (lock *workspaceLock) writeHolder() {
+	mode := "shared"
+	if lock.exclusive {
+		mode = "exclusive"
+	}
+	command := append([]string{filepath.Base(os.Args[0])}, os.Args[1:]...)
+	ioutil.WriteFile(lock.holder, []byte(mode+" "+strings.Join(command, " ")), 0644)
+}
+
+// Unlock releases the lock.
+func (lock *workspaceLock) Unlock() {
+	os.Remove(lock.holder)
+	if lock.exclusive {
+		lock.mutex.Unlock()
+	} else {
+		lock.mutex.RUnlock()
+	}
+	lock.mutex.Close()
+}
+
+// lockHolders names the other processes which hold the workspace lock, and
+// removes the files of processes which have exited.
+func lockHolders(workspace string) []string {
+	dir := shutil.Path(workspace, ".bottle-lock.d")
+	names, _ := ioutil.ReadDir(dir)
+
+	var holders []string
+	for _, info := range names {
+		pid, err := strconv.Atoi(info.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		if !processExists(pid) {
+			os.Remove(shutil.Path(dir, info.Name())) // NOTE: the process has exited
+			continue
+		}
+		data, err := ioutil.ReadFile(shutil.Path(dir, info.Name()))
+		if err != nil {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSpace(string(data)), " ", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		holders = append(holders, fmt.Sprintf("pid %d (%s, %s)", pid, parts[1], parts[0]))
+	}
+
+	sort.Strings(holders)
+	return holders
+}